@@ -0,0 +1,66 @@
+package sps30
+
+import (
+	"io"
+	"sync"
+
+	"github.com/MasandeM/sps30/shdlc"
+)
+
+// Bus is a transport shared by several SHDLC devices with distinct addresses, such as an RS-485 adapter
+// with multiple sensors connected. Commands to any Device of a Bus are executed one after the other,
+// so each response is read by the Device waiting for it.
+type Bus struct {
+	mu     *sync.Mutex
+	uart   Transport
+	client *shdlc.Client
+}
+
+// NewBus creates a Bus communicating over uart, which must satisfy the same requirements as for New
+func NewBus(uart Transport) *Bus {
+	return &Bus{
+		mu:     new(sync.Mutex),
+		uart:   uart,
+		client: shdlc.NewClient(uart, 0),
+	}
+}
+
+// OpenBus opens the serial port at path with the settings required by the SPS30, like Open, and returns a Bus on it
+func OpenBus(path string) (*Bus, error) {
+	port, err := openPort(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewBus(port), nil
+}
+
+// Device returns a Device for the sensor at addr, configured by opts. A WithAddress option is ignored.
+func (b *Bus) Device(addr uint8, opts ...Option) Device {
+	c := newConfig(opts)
+	c.addr = addr
+
+	d := newDevice(b.uart, b.mu, b.client, c)
+	d.shared = true
+
+	return d
+}
+
+// Client returns the SHDLC client of the Bus and the lock that serializes its use, so drivers of other
+// SHDLC devices on the same transport, like sen5x.NewShared, take turns with the SPS30 Devices.
+// The lock must be held from sending a request until its response has been received.
+func (b *Bus) Client() (*shdlc.Client, *sync.Mutex) {
+	return b.client, b.mu
+}
+
+// Close closes the transport if it implements io.Closer. Devices of the Bus can't be used afterwards.
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if closer, ok := b.uart.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package sps30_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MasandeM/sps30"
+	"github.com/MasandeM/sps30/sen5x"
+	"github.com/MasandeM/sps30/simulator"
+)
+
+// multiDrop connects several simulated sensors to one transport, like an RS-485 bus.
+// Every sensor receives all frames, and a Read returns whatever any of them sent.
+type multiDrop struct {
+	sensors []*simulator.Simulator
+	closes  *int
+}
+
+func newMultiDrop(sensors ...*simulator.Simulator) multiDrop {
+	for _, sensor := range sensors {
+		sensor.SetReadTimeout(0)
+	}
+	return multiDrop{sensors: sensors, closes: new(int)}
+}
+
+func (m multiDrop) Write(p []byte) (int, error) {
+	for _, sensor := range m.sensors {
+		sensor.Write(p)
+	}
+	return len(p), nil
+}
+
+func (m multiDrop) Read(p []byte) (int, error) {
+	for _, sensor := range m.sensors {
+		if n, _ := sensor.Read(p); n > 0 {
+			return n, nil
+		}
+	}
+	time.Sleep(time.Millisecond)
+	return 0, nil
+}
+
+func (m multiDrop) Close() error {
+	*m.closes += 1
+	return nil
+}
+
+func TestBusDevices(t *testing.T) {
+	bus := sps30.NewBus(newMultiDrop(
+		simulator.New(simulator.WithAddress(1), simulator.WithDeviceInfo("00080000", "SENSOR1")),
+		simulator.New(simulator.WithAddress(2), simulator.WithDeviceInfo("00080000", "SENSOR2")),
+	))
+
+	for _, addr := range []uint8{1, 2} {
+		device := bus.Device(addr, sps30.WithResponseTimeout(50*time.Millisecond))
+
+		info, err := device.ReadDeviceInfo()
+		if err != nil {
+			t.Fatalf("ReadDeviceInfo() at address %d failed: %v", addr, err)
+		}
+		if want := []string{"", "SENSOR1", "SENSOR2"}[addr]; info.SerialNumber != want {
+			t.Errorf("ReadDeviceInfo() at address %d = %+v. Expected serial number %s", addr, info, want)
+		}
+	}
+
+	missing := bus.Device(3, sps30.WithResponseTimeout(20*time.Millisecond))
+	if err := missing.ReadVersion(&sps30.VersionInfo{}); !errors.Is(err, sps30.ErrMissingStart) {
+		t.Errorf("ReadVersion() at address 3 error = %v. Expected %v", err, sps30.ErrMissingStart)
+	}
+}
+
+func TestBusConcurrentDevices(t *testing.T) {
+	bus := sps30.NewBus(newMultiDrop(
+		simulator.New(simulator.WithAddress(1), simulator.WithDeviceInfo("00080000", "SENSOR1")),
+		simulator.New(simulator.WithAddress(2), simulator.WithDeviceInfo("00080000", "SENSOR2")),
+	))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+
+	for _, addr := range []uint8{1, 2} {
+		device := bus.Device(addr, sps30.WithResponseTimeout(100*time.Millisecond))
+		want := []string{"", "SENSOR1", "SENSOR2"}[addr]
+
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				info, err := device.ReadDeviceInfo()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if info.SerialNumber != want {
+					errs <- errors.New("response of another device: " + info.SerialNumber)
+				}
+			}()
+		}
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestBusSharedWithSEN5x(t *testing.T) {
+	bus := sps30.NewBus(newMultiDrop(
+		simulator.New(simulator.WithAddress(1), simulator.WithDeviceInfo("00080000", "SENSOR1")),
+		simulator.New(simulator.WithAddress(2), simulator.WithModel(simulator.ModelSEN55)),
+	))
+
+	client, mu := bus.Client()
+	sps := bus.Device(1, sps30.WithResponseTimeout(100*time.Millisecond))
+	sen := sen5x.NewShared(client, mu, sen5x.WithAddress(2), sen5x.WithResponseTimeout(100*time.Millisecond))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+
+			info, err := sps.ReadDeviceInfo()
+			if err != nil {
+				errs <- err
+			} else if info.SerialNumber != "SENSOR1" {
+				errs <- errors.New("response of another device: " + info.SerialNumber)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+
+			name, err := sen.ReadProductName()
+			if err != nil {
+				errs <- err
+			} else if name != "SEN55" {
+				errs <- errors.New("response of another device: " + name)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestBusClose(t *testing.T) {
+	transport := newMultiDrop(simulator.New(simulator.WithAddress(1)))
+	bus := sps30.NewBus(transport)
+
+	device := bus.Device(1, sps30.WithResponseTimeout(50*time.Millisecond))
+	if err := device.StartMeasurement(); err != nil {
+		t.Fatalf("StartMeasurement() failed: %v", err)
+	}
+
+	if err := device.Close(); err != nil {
+		t.Errorf("Close() failed: %v", err)
+	}
+	if transport.sensors[0].Mode() != simulator.ModeIdle {
+		t.Errorf("Close() did not stop the measurement")
+	}
+	if *transport.closes != 0 {
+		t.Errorf("Close() of a Device closed the shared transport")
+	}
+
+	if err := bus.Close(); err != nil {
+		t.Errorf("Bus.Close() failed: %v", err)
+	}
+	if *transport.closes != 1 {
+		t.Errorf("Bus.Close() closed the transport %d times. Expected 1", *transport.closes)
+	}
+}
@@ -0,0 +1,111 @@
+package sps30
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MasandeM/sps30/shdlc"
+	"go.bug.st/serial"
+)
+
+// default time a port gets to answer each probe during discovery
+const discoverResponseTimeout = 100 * time.Millisecond
+
+// product type reported by every SPS30
+const productType = "00080000"
+
+// listSerialPorts enumerates the serial ports of the system, replaced in tests
+var listSerialPorts = serial.GetPortsList
+
+// DiscoveredSensor describes an SPS30 found on a serial port by Discover
+type DiscoveredSensor struct {
+	Port    string
+	Info    DeviceInfo
+	Version VersionInfo
+}
+
+// Discover probes every serial port of the system for an SPS30 and returns the sensors that answered,
+// ordered by port. Ports are probed concurrently with a response timeout of 100ms unless opts set
+// another one, and ports that can't be opened or don't answer as an SPS30 are skipped.
+// Options other than WithResponseTimeout, WithAddress, WithWakeup and WithLogger have no effect.
+func Discover(ctx context.Context, opts ...OpenOption) ([]DiscoveredSensor, error) {
+	ports, err := listSerialPorts()
+	if err != nil {
+		return nil, fmt.Errorf("could not list serial ports: %w", err)
+	}
+
+	opts = append([]OpenOption{WithResponseTimeout(discoverResponseTimeout)}, opts...)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sensors := []DiscoveredSensor{}
+
+	for _, port := range ports {
+		wg.Add(1)
+		go func(port string) {
+			defer wg.Done()
+
+			sensor, ok := probePort(ctx, port, opts)
+			if !ok {
+				return
+			}
+
+			mu.Lock()
+			sensors = append(sensors, sensor)
+			mu.Unlock()
+		}(port)
+	}
+
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("discovery interrupted: %w", err)
+	}
+
+	sort.Slice(sensors, func(i, j int) bool {
+		return sensors[i].Port < sensors[j].Port
+	})
+
+	return sensors, nil
+}
+
+// probePort reads the version and device info of an SPS30 on port, reporting whether one answered
+func probePort(ctx context.Context, port string, opts []OpenOption) (DiscoveredSensor, bool) {
+	uart, err := openSerialPort(port, &serialMode)
+	if err != nil {
+		return DiscoveredSensor{}, false
+	}
+
+	c := newOpenConfig(opts)
+	device := newDevice(uart, new(sync.Mutex), shdlc.NewClient(uart, 0), c.config)
+	defer uart.Close()
+
+	if c.wakeup {
+		_ = device.WakeupContext(ctx)
+	}
+
+	sensor := DiscoveredSensor{Port: port}
+
+	err = device.ReadVersionContext(ctx, &sensor.Version)
+	if err != nil {
+		device.logf("sps30: no SPS30 found on %s: %v", port, err)
+		return DiscoveredSensor{}, false
+	}
+
+	sensor.Info, err = device.ReadDeviceInfoContext(ctx)
+	if err != nil {
+		device.logf("sps30: could not read device info on %s: %v", port, err)
+		return DiscoveredSensor{}, false
+	}
+
+	// other SHDLC devices, like the SEN5x, answer the same requests
+	if sensor.Info.ProductType != productType {
+		device.logf("sps30: device on %s is not an SPS30: product type %q", port, sensor.Info.ProductType)
+		return DiscoveredSensor{}, false
+	}
+
+	return sensor, true
+}
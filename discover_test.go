@@ -0,0 +1,101 @@
+package sps30_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MasandeM/sps30"
+	"go.bug.st/serial"
+)
+
+var productTypeResponse = []byte{0x7e, 0x00, 0xd0, 0x00, 0x09, 0x30, 0x30, 0x30, 0x38, 0x30, 0x30, 0x30, 0x30, 0x00, 0x9e, 0x7e}
+var serialNumberResponse = []byte{0x7e, 0x00, 0xd0, 0x00, 0x7d, 0x31, 0x32, 0x44, 0x36, 0x42, 0x36, 0x43, 0x33, 0x42, 0x31, 0x42, 0x34, 0x45, 0x30, 0x41, 0x34, 0x43, 0x00, 0x6e, 0x7e}
+
+// stubSerialPorts makes Discover find the given ports, a nil port failing to open
+func stubSerialPorts(t *testing.T, ports map[string]serial.Port) {
+	originalList := *sps30.ListSerialPorts
+	originalOpen := *sps30.OpenSerialPort
+	t.Cleanup(func() {
+		*sps30.ListSerialPorts = originalList
+		*sps30.OpenSerialPort = originalOpen
+	})
+
+	*sps30.ListSerialPorts = func() ([]string, error) {
+		paths := []string{}
+		for path := range ports {
+			paths = append(paths, path)
+		}
+		return paths, nil
+	}
+
+	*sps30.OpenSerialPort = func(p string, m *serial.Mode) (serial.Port, error) {
+		if ports[p] == nil {
+			return nil, errors.New("port busy")
+		}
+		return ports[p], nil
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	sensorA := newScriptedUart(versionResponse, productTypeResponse, serialNumberResponse)
+	sensorB := newScriptedUart(versionResponse, productTypeResponse, serialNumberResponse)
+	silent := newDribbleUart(nil)
+	// a SEN55 speaks SHDLC too, but reports its product name as product type
+	sen55 := newScriptedUart(
+		versionResponse,
+		[]byte{0x7e, 0x00, 0xd0, 0x00, 0x06, 0x53, 0x45, 0x4e, 0x35, 0x35, 0x00, 0xd9, 0x7e},
+		serialNumberResponse,
+	)
+
+	stubSerialPorts(t, map[string]serial.Port{
+		"/dev/ttyUSB1": sensorB,
+		"/dev/ttyUSB0": sensorA,
+		"/dev/ttyUSB2": sen55,
+		"/dev/ttyS0":   silent,
+		"/dev/ttyS1":   nil,
+	})
+
+	start := time.Now()
+	sensors, err := sps30.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("Discover() failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Discover() took %v waiting for a silent port", elapsed)
+	}
+
+	if len(sensors) != 2 {
+		t.Fatalf("Discover() found %d sensors. Expected 2: %+v", len(sensors), sensors)
+	}
+
+	for i, port := range []string{"/dev/ttyUSB0", "/dev/ttyUSB1"} {
+		if sensors[i].Port != port {
+			t.Errorf("sensors[%d].Port = %q. Expected %q", i, sensors[i].Port, port)
+		}
+		want := sps30.DeviceInfo{ProductType: "00080000", SerialNumber: "2D6B6C3B1B4E0A4C"}
+		if sensors[i].Info != want {
+			t.Errorf("sensors[%d].Info = %+v. Expected %+v", i, sensors[i].Info, want)
+		}
+		if sensors[i].Version.FirmwarMajor != 2 || sensors[i].Version.FirmwarMinor != 3 {
+			t.Errorf("sensors[%d].Version = %+v. Expected firmware 2.3", i, sensors[i].Version)
+		}
+	}
+
+	if *sensorA.closes != 1 || *sensorB.closes != 1 || *sen55.closes != 1 {
+		t.Errorf("Discover() did not close the probed ports")
+	}
+}
+
+func TestDiscoverCanceled(t *testing.T) {
+	stubSerialPorts(t, map[string]serial.Port{"/dev/ttyS0": newDribbleUart(nil)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := sps30.Discover(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Discover() error = %v. Expected %v", err, context.Canceled)
+	}
+}
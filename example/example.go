@@ -1,34 +1,27 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"time"
 
 	"github.com/MasandeM/sps30"
-
-	"go.bug.st/serial"
 )
 
 func main() {
 
-	mode := &serial.Mode{
-		BaudRate: 115200,
-		DataBits: 8,
-		Parity:   serial.NoParity,
-		StopBits: serial.OneStopBit,
-	}
-
 	log.Println("Connecting to UART")
 
-	uart, err := serial.Open("/dev/ttyUSB0", mode) //should be read from a config file or something
+	device, err := sps30.Open("/dev/ttyUSB0", sps30.WithWakeup()) //should be read from a config file or something
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer device.Close()
 	log.Println("Successfully Connected")
 
-	device := sps30.New(uart)
-
 	//Create a struct that is passed to a function then is populated. th
 	version_info := sps30.VersionInfo{}
 	err = device.ReadVersion(&version_info)
@@ -44,20 +37,20 @@ func main() {
 		version_info.SHDLCMajor,
 		version_info.SHDLCMinor)
 
-	err = device.StartMeasurement()
-	if err != nil {
-		log.Fatal("error starting measurement")
-	}
+	// the measurement is started, and stopped again on Ctrl+C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-	measurement := sps30.Measurement{}
-	for {
-
-		err = device.ReadMeasurement(&measurement)
-		if err != nil {
-			fmt.Printf("[-] error reading measurement: %v\n", err)
-		} else {
+	samples, errs := device.Stream(ctx, time.Second)
+	for samples != nil || errs != nil {
+		select {
+		case sample, ok := <-samples:
+			if !ok {
+				samples = nil
+				continue
+			}
 			fmt.Printf(`
-measured values:
+measured values at %v:
 				%0.2f pm1.0
 				%0.2f pm2.5
 				%0.2f pm4.0
@@ -69,11 +62,17 @@ measured values:
 				%0.2f nc10.0
 				%0.2f typical particle size
 `,
-				measurement.Mc1p0, measurement.Mc2p5, measurement.Mc4p0, measurement.Mc10p0, measurement.Nc0p5,
-				measurement.Nc1p0, measurement.Nc2p5, measurement.Nc4p0, measurement.Nc10p0,
-				measurement.TypicalParticleSize)
-		}
+				sample.Time.Format(time.TimeOnly),
+				sample.Mc1p0, sample.Mc2p5, sample.Mc4p0, sample.Mc10p0, sample.Nc0p5,
+				sample.Nc1p0, sample.Nc2p5, sample.Nc4p0, sample.Nc10p0,
+				sample.TypicalParticleSize)
 
-		time.Sleep(time.Second)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			fmt.Printf("[-] error reading measurement: %v\n", err)
+		}
 	}
 }
@@ -0,0 +1,390 @@
+package sps30
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// I2CAddr is the fixed I2C address of the SPS30
+const I2CAddr = 0x69
+
+const i2cCmdStartMeasurement = 0x0010
+const i2cCmdStopMeasurement = 0x0104
+const i2cCmdReadDataReady = 0x0202
+const i2cCmdReadMeasurement = 0x0300
+const i2cCmdSleep = 0x1001
+const i2cCmdWakeUp = 0x1103
+const i2cCmdStartFanCleaning = 0x5607
+const i2cCmdAutoCleaningInterval = 0x8004
+const i2cCmdReadProductType = 0xd002
+const i2cCmdReadSerialNumber = 0xd033
+const i2cCmdReadVersion = 0xd100
+const i2cCmdReadStatusRegister = 0xd206
+const i2cCmdClearStatusRegister = 0xd210
+const i2cCmdReset = 0xd304
+
+// time the device needs to execute a command before its response can be read
+const i2cCommandDelay = 5 * time.Millisecond
+
+// time the device needs to enter Measure-mode
+const i2cStartMeasurementDelay = 20 * time.Millisecond
+
+const i2cCRC8Polynomial = 0x31
+const i2cCRC8Init = 0xff
+
+// I2CBus is an I2C bus the SPS30 is connected to.
+// Tx writes w to the peripheral at addr, then reads len(r) bytes from it into r.
+// Either w or r may be empty, in which case that part of the transaction is skipped.
+type I2CBus interface {
+	Tx(addr uint16, w, r []byte) error
+}
+
+// I2CDevice represents an SPS30 device connected over I2C.
+// It offers the same operations as Device, with every 2-byte word on the bus protected by a CRC-8.
+// Like a Device it is safe for concurrent use, each operation holds the device's lock until the
+// device has responded, and copies of an I2CDevice share its lock and output format.
+type I2CDevice struct {
+	mu     *sync.Mutex
+	bus    I2CBus
+	addr   uint16
+	format *OutputFormat
+}
+
+// NewI2C creates and initialises a new SPS30 I2CDevice on bus
+func NewI2C(bus I2CBus) I2CDevice {
+	format := OutputFormatFloat
+
+	return I2CDevice{
+		mu:     new(sync.Mutex),
+		bus:    bus,
+		addr:   I2CAddr,
+		format: &format,
+	}
+}
+
+// Wakeup switches the device from sleep-mode to idle mode.
+// The first wake-up command only activates the I2C interface and is not acknowledged, so it is sent twice.
+func (d *I2CDevice) Wakeup() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_ = d.bus.Tx(d.addr, i2cCommand(i2cCmdWakeUp, nil), nil)
+
+	err := d.write(i2cCmdWakeUp, nil)
+	if err != nil {
+		return fmt.Errorf("unable to WakeUP SPS30 device: %w", err)
+	}
+
+	return nil
+}
+
+// Sleep switches the device from idle mode to sleep-mode. The device must be in idle mode.
+func (d *I2CDevice) Sleep() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := d.write(i2cCmdSleep, nil)
+	if err != nil {
+		return fmt.Errorf("could not put device to sleep: %w", err)
+	}
+
+	return nil
+}
+
+// ReadVersion populates the firmware version of a VersionInfo struct.
+// The hardware revision and SHDLC protocol version are not available over I2C.
+func (d *I2CDevice) ReadVersion(version_info *VersionInfo) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	data := make([]byte, 2)
+
+	err := d.read(i2cCmdReadVersion, data)
+	if err != nil {
+		return fmt.Errorf("could not read version info from device: %w", err)
+	}
+
+	version_info.FirmwarMajor = data[0]
+	version_info.FirmwarMinor = data[1]
+
+	return nil
+}
+
+// ReadDeviceInfo reads the product type and serial number of the device
+func (d *I2CDevice) ReadDeviceInfo() (DeviceInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	product_type := make([]byte, 8)
+
+	err := d.read(i2cCmdReadProductType, product_type)
+	if err != nil {
+		return DeviceInfo{}, fmt.Errorf("could not read product type from device: %w", err)
+	}
+
+	serial_number := make([]byte, deviceInfoMaxLen)
+
+	err = d.read(i2cCmdReadSerialNumber, serial_number)
+	if err != nil {
+		return DeviceInfo{}, fmt.Errorf("could not read serial number from device: %w", err)
+	}
+
+	return DeviceInfo{
+		ProductType:  bytesString(product_type),
+		SerialNumber: bytesString(serial_number),
+	}, nil
+}
+
+// ReadStatusRegister reads the device status register, optionally clearing it afterwards.
+func (d *I2CDevice) ReadStatusRegister(clear bool) (StatusRegister, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	data := make([]byte, 4)
+
+	err := d.read(i2cCmdReadStatusRegister, data)
+	if err != nil {
+		return StatusRegister{}, fmt.Errorf("could not read status register from device: %w", err)
+	}
+
+	if clear {
+		err = d.write(i2cCmdClearStatusRegister, nil)
+		if err != nil {
+			return StatusRegister{}, fmt.Errorf("could not clear status register: %w", err)
+		}
+	}
+
+	raw := binary.BigEndian.Uint32(data)
+
+	return StatusRegister{
+		Raw:             raw,
+		FanSpeedWarning: raw&statusFanSpeedWarning != 0,
+		LaserFailure:    raw&statusLaserFailure != 0,
+		FanFailure:      raw&statusFanFailure != 0,
+	}, nil
+}
+
+// Reset performs a soft reset of the device and waits for it to boot.
+func (d *I2CDevice) Reset() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := d.write(i2cCmdReset, nil)
+	if err != nil {
+		return fmt.Errorf("could not reset device: %w", err)
+	}
+
+	time.Sleep(resetBootTime)
+
+	return nil
+}
+
+// StartMeasurement puts the SPS30 in Measure-mode, reporting values as floats.
+func (d *I2CDevice) StartMeasurement() error {
+	return d.StartMeasurementFormat(OutputFormatFloat)
+}
+
+// StartMeasurementFormat puts the SPS30 in Measure-mode, reporting values in the given format.
+func (d *I2CDevice) StartMeasurementFormat(format OutputFormat) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if format != OutputFormatFloat && format != OutputFormatUint16 {
+		return fmt.Errorf("unsupported measurement output format 0x%02x", uint8(format))
+	}
+
+	err := d.write(i2cCmdStartMeasurement, []byte{uint8(format), 0x00})
+	if err != nil {
+		return fmt.Errorf("could not start measurement: %w", err)
+	}
+
+	*d.format = format
+	time.Sleep(i2cStartMeasurementDelay)
+
+	return nil
+}
+
+// StopMeasurement stops the measurement and returns the SPS30 to Idle-mode.
+func (d *I2CDevice) StopMeasurement() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := d.write(i2cCmdStopMeasurement, nil)
+	if err != nil {
+		return fmt.Errorf("could not stop measurement: %w", err)
+	}
+
+	return nil
+}
+
+// DataReady reports whether new measured values are available to be read
+func (d *I2CDevice) DataReady() (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.dataReady()
+}
+
+// dataReady is DataReady for callers holding the device's lock
+func (d *I2CDevice) dataReady() (bool, error) {
+	data := make([]byte, 2)
+
+	err := d.read(i2cCmdReadDataReady, data)
+	if err != nil {
+		return false, fmt.Errorf("could not read data-ready flag from device: %w", err)
+	}
+
+	return data[1] == 0x01, nil
+}
+
+// ReadMeasurement reads measurement values from sps30 device.
+// The data-ready flag is checked first, and ErrNoNewData is returned if no new values were measured since the last read.
+func (d *I2CDevice) ReadMeasurement(measurement *Measurement) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ready, err := d.dataReady()
+	if err != nil {
+		return err
+	}
+
+	if !ready {
+		return ErrNoNewData
+	}
+
+	data := make([]byte, measurementDataLen(*d.format))
+
+	err = d.read(i2cCmdReadMeasurement, data)
+	if err != nil {
+		return fmt.Errorf("could not read measurement from device: %w", err)
+	}
+
+	decodeMeasurement(*d.format, data, measurement)
+
+	return nil
+}
+
+// StartFanCleaning starts the fan-cleaning manually. The device must be in Measure-mode.
+func (d *I2CDevice) StartFanCleaning() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := d.write(i2cCmdStartFanCleaning, nil)
+	if err != nil {
+		return fmt.Errorf("could not start fan cleaning: %w", err)
+	}
+
+	return nil
+}
+
+// ReadAutoCleaningInterval reads the interval at which the device automatically cleans its fan.
+func (d *I2CDevice) ReadAutoCleaningInterval() (time.Duration, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	data := make([]byte, 4)
+
+	err := d.read(i2cCmdAutoCleaningInterval, data)
+	if err != nil {
+		return 0, fmt.Errorf("could not read auto cleaning interval from device: %w", err)
+	}
+
+	return time.Duration(binary.BigEndian.Uint32(data)) * time.Second, nil
+}
+
+// WriteAutoCleaningInterval sets the interval at which the device automatically cleans its fan.
+// The same limits as for Device.WriteAutoCleaningInterval apply.
+func (d *I2CDevice) WriteAutoCleaningInterval(interval time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if interval < 0 || interval/time.Second > math.MaxUint32 {
+		return fmt.Errorf("auto cleaning interval %v out of range [0s, %ds]", interval, uint32(math.MaxUint32))
+	}
+
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, uint32(interval/time.Second))
+
+	err := d.write(i2cCmdAutoCleaningInterval, data)
+	if err != nil {
+		return fmt.Errorf("could not write auto cleaning interval to device: %w", err)
+	}
+
+	return nil
+}
+
+// write sends a command with its arguments to the device. The caller must hold d.mu.
+func (d *I2CDevice) write(cmd uint16, args []byte) error {
+	return d.bus.Tx(d.addr, i2cCommand(cmd, args), nil)
+}
+
+// read sends a command to the device and reads len(data) bytes of its response into data.
+// The caller must hold d.mu, so no other command is sent before the response has been read.
+func (d *I2CDevice) read(cmd uint16, data []byte) error {
+	err := d.bus.Tx(d.addr, i2cCommand(cmd, nil), nil)
+	if err != nil {
+		return err
+	}
+
+	time.Sleep(i2cCommandDelay)
+
+	rx := make([]byte, len(data)/2*3)
+
+	err = d.bus.Tx(d.addr, nil, rx)
+	if err != nil {
+		return err
+	}
+
+	return i2cUnpackWords(rx, data)
+}
+
+// i2cCommand encodes a 16-bit command followed by its arguments, each 2-byte word followed by its CRC
+func i2cCommand(cmd uint16, args []byte) []byte {
+	frame := make([]byte, 2, 2+len(args)/2*3)
+	binary.BigEndian.PutUint16(frame, cmd)
+
+	for i := 0; i+1 < len(args); i += 2 {
+		frame = append(frame, args[i], args[i+1], i2cCRC8(args[i:i+2]))
+	}
+
+	return frame
+}
+
+// i2cUnpackWords verifies the CRC of every 2-byte word in rx and copies the words into data
+func i2cUnpackWords(rx []byte, data []byte) error {
+	if len(rx) != len(data)/2*3 {
+		return errors.New("did not receive enough data from device")
+	}
+
+	for i := 0; i < len(data)/2; i++ {
+		word := rx[i*3 : i*3+2]
+		if i2cCRC8(word) != rx[i*3+2] {
+			return fmt.Errorf("%w in word %d", ErrCRCMismatch, i)
+		}
+		copy(data[i*2:], word)
+	}
+
+	return nil
+}
+
+// i2cCRC8 calculates the Sensirion CRC-8 (polynomial 0x31, init 0xFF) of data
+func i2cCRC8(data []byte) uint8 {
+	crc := uint8(i2cCRC8Init)
+
+	for _, b := range data {
+		crc ^= b
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ i2cCRC8Polynomial
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
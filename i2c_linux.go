@@ -0,0 +1,75 @@
+//go:build linux
+
+package sps30
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// ioctl selecting the peripheral address used by subsequent reads and writes, from linux/i2c-dev.h
+const ioctlI2CSlave = 0x0703
+
+// LinuxI2CBus is an I2CBus backed by a Linux i2c-dev character device such as /dev/i2c-1
+type LinuxI2CBus struct {
+	mu   sync.Mutex
+	file *os.File
+	addr uint16
+	set  bool
+}
+
+// OpenI2CBus opens the i2c-dev character device at path, e.g. "/dev/i2c-1"
+func OpenI2CBus(path string) (*LinuxI2CBus, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not open I2C bus: %w", err)
+	}
+
+	return &LinuxI2CBus{file: file}, nil
+}
+
+// Tx writes w to the peripheral at addr, then reads len(r) bytes from it into r
+func (b *LinuxI2CBus) Tx(addr uint16, w, r []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.set || b.addr != addr {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, b.file.Fd(), ioctlI2CSlave, uintptr(addr))
+		if errno != 0 {
+			return fmt.Errorf("could not select I2C address 0x%02x: %w", addr, errno)
+		}
+		b.addr = addr
+		b.set = true
+	}
+
+	// i2c-dev transfers each Read and Write as a single message, so a short count is not resumed
+	if len(w) > 0 {
+		n, err := b.file.Write(w)
+		if err != nil {
+			return fmt.Errorf("I2C write to 0x%02x failed: %w", addr, err)
+		}
+		if n < len(w) {
+			return fmt.Errorf("I2C write to 0x%02x failed after %d of %d bytes: %w", addr, n, len(w), io.ErrShortWrite)
+		}
+	}
+
+	if len(r) > 0 {
+		n, err := b.file.Read(r)
+		if err != nil {
+			return fmt.Errorf("I2C read from 0x%02x failed: %w", addr, err)
+		}
+		if n < len(r) {
+			return fmt.Errorf("I2C read from 0x%02x failed after %d of %d bytes: %w", addr, n, len(r), io.ErrUnexpectedEOF)
+		}
+	}
+
+	return nil
+}
+
+// Close closes the i2c-dev character device
+func (b *LinuxI2CBus) Close() error {
+	return b.file.Close()
+}
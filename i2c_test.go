@@ -0,0 +1,268 @@
+package sps30_test
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MasandeM/sps30"
+)
+
+// fakeI2CBus answers reads with the canned payload of the last command written to it,
+// adding the CRC to every 2-byte word, and records every write.
+type fakeI2CBus struct {
+	responses map[uint16][]byte
+	written   [][]byte
+	last      uint16
+	corrupt   bool
+}
+
+func (b *fakeI2CBus) Tx(addr uint16, w, r []byte) error {
+	if addr != sps30.I2CAddr {
+		return errors.New("no device acknowledged the address")
+	}
+
+	if len(w) > 0 {
+		b.written = append(b.written, append([]byte{}, w...))
+		b.last = binary.BigEndian.Uint16(w)
+	}
+
+	if len(r) > 0 {
+		payload := b.responses[b.last]
+		for i := 0; i+1 < len(payload) && i/2*3+2 < len(r); i += 2 {
+			word := payload[i : i+2]
+			j := i / 2 * 3
+			r[j], r[j+1], r[j+2] = word[0], word[1], sps30.I2CCRC8(word)
+			if b.corrupt {
+				r[j+2] ^= 0xff
+			}
+		}
+	}
+
+	return nil
+}
+
+func TestI2CCRC8(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want uint8
+	}{
+		{data: []byte{0xbe, 0xef}, want: 0x92},
+		{data: []byte{0x03, 0x00}, want: 0xac},
+		{data: []byte{0x05, 0x00}, want: 0xf6},
+	}
+	for _, test := range tests {
+		if got := sps30.I2CCRC8(test.data); got != test.want {
+			t.Errorf("i2cCRC8(0x%x) = 0x%x. Expected 0x%x", test.data, got, test.want)
+		}
+	}
+}
+
+func TestI2CStartMeasurement(t *testing.T) {
+	tests := []struct {
+		format sps30.OutputFormat
+		want   string
+	}{
+		{format: sps30.OutputFormatFloat, want: "00100300ac"},
+		{format: sps30.OutputFormatUint16, want: "00100500f6"},
+	}
+	for _, test := range tests {
+		bus := &fakeI2CBus{}
+		device := sps30.NewI2C(bus)
+
+		if err := device.StartMeasurementFormat(test.format); err != nil {
+			t.Errorf("StartMeasurementFormat(0x%x) failed: %v", test.format, err)
+		}
+		if got := hex.EncodeToString(bus.written[0]); got != test.want {
+			t.Errorf("StartMeasurementFormat(0x%x) sent 0x%v. Expected 0x%v", test.format, got, test.want)
+		}
+	}
+}
+
+func TestI2CReadMeasurement(t *testing.T) {
+	values := []float32{1.5, 2.5, 3.5, 4.5, 10, 11, 12, 13, 14, 0.6}
+	payload := make([]byte, 40)
+	for i, v := range values {
+		binary.BigEndian.PutUint32(payload[i*4:], math.Float32bits(v))
+	}
+
+	bus := &fakeI2CBus{responses: map[uint16][]byte{
+		0x0202: {0x00, 0x01},
+		0x0300: payload,
+	}}
+	device := sps30.NewI2C(bus)
+
+	ready, err := device.DataReady()
+	if err != nil || !ready {
+		t.Fatalf("DataReady() = %v, %v. Expected true", ready, err)
+	}
+
+	measurement := sps30.Measurement{}
+	if err := device.ReadMeasurement(&measurement); err != nil {
+		t.Fatalf("ReadMeasurement() failed: %v", err)
+	}
+
+	want := sps30.Measurement{
+		Mc1p0: 1.5, Mc2p5: 2.5, Mc4p0: 3.5, Mc10p0: 4.5,
+		Nc0p5: 10, Nc1p0: 11, Nc2p5: 12, Nc4p0: 13, Nc10p0: 14,
+		TypicalParticleSize: 0.6,
+	}
+	if measurement != want {
+		t.Errorf("ReadMeasurement() = %+v. Expected %+v", measurement, want)
+	}
+}
+
+func TestI2CReadMeasurementNoNewData(t *testing.T) {
+	bus := &fakeI2CBus{responses: map[uint16][]byte{
+		0x0202: {0x00, 0x00},
+		0x0300: make([]byte, 40),
+	}}
+	device := sps30.NewI2C(bus)
+
+	err := device.ReadMeasurement(&sps30.Measurement{})
+	if !errors.Is(err, sps30.ErrNoNewData) {
+		t.Errorf("ReadMeasurement() error = %v. Expected %v", err, sps30.ErrNoNewData)
+	}
+	for _, w := range bus.written {
+		if hex.EncodeToString(w) == "0300" {
+			t.Errorf("ReadMeasurement() read measured values although no new data was ready")
+		}
+	}
+}
+
+func TestI2CReadDeviceInfo(t *testing.T) {
+	serial := make([]byte, 32)
+	copy(serial, "8A5F2B1C3D4E6F70")
+
+	bus := &fakeI2CBus{responses: map[uint16][]byte{
+		0xd002: []byte("00080000"),
+		0xd033: serial,
+		0xd100: {0x02, 0x03},
+	}}
+	device := sps30.NewI2C(bus)
+
+	info, err := device.ReadDeviceInfo()
+	if err != nil {
+		t.Fatalf("ReadDeviceInfo() failed: %v", err)
+	}
+	want := sps30.DeviceInfo{ProductType: "00080000", SerialNumber: "8A5F2B1C3D4E6F70"}
+	if info != want {
+		t.Errorf("ReadDeviceInfo() = %+v. Expected %+v", info, want)
+	}
+
+	version := sps30.VersionInfo{}
+	if err := device.ReadVersion(&version); err != nil {
+		t.Fatalf("ReadVersion() failed: %v", err)
+	}
+	if version.FirmwarMajor != 2 || version.FirmwarMinor != 3 {
+		t.Errorf("ReadVersion() = %+v. Expected firmware 2.3", version)
+	}
+}
+
+func TestI2CConcurrentReads(t *testing.T) {
+	bus := &fakeI2CBus{responses: map[uint16][]byte{
+		0xd100: {0x02, 0x03},
+		0x8004: {0x00, 0x09, 0x3a, 0x80},
+	}}
+	device := sps30.NewI2C(bus)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+
+			version := sps30.VersionInfo{}
+			if err := device.ReadVersion(&version); err != nil {
+				errs <- err
+			} else if version.FirmwarMajor != 2 || version.FirmwarMinor != 3 {
+				errs <- errors.New("ReadVersion() read the response to another command")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+
+			interval, err := device.ReadAutoCleaningInterval()
+			if err != nil {
+				errs <- err
+			} else if interval != 7*24*time.Hour {
+				errs <- errors.New("ReadAutoCleaningInterval() read the response to another command")
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestI2CAutoCleaningInterval(t *testing.T) {
+	bus := &fakeI2CBus{responses: map[uint16][]byte{
+		0x8004: {0x00, 0x09, 0x3a, 0x80},
+	}}
+	device := sps30.NewI2C(bus)
+
+	interval, err := device.ReadAutoCleaningInterval()
+	if err != nil {
+		t.Fatalf("ReadAutoCleaningInterval() failed: %v", err)
+	}
+	if interval != 7*24*time.Hour {
+		t.Errorf("ReadAutoCleaningInterval() = %v. Expected %v", interval, 7*24*time.Hour)
+	}
+
+	if err := device.WriteAutoCleaningInterval(7 * 24 * time.Hour); err != nil {
+		t.Fatalf("WriteAutoCleaningInterval() failed: %v", err)
+	}
+	if got := hex.EncodeToString(bus.written[len(bus.written)-1]); got != "80040009093a80a7" {
+		t.Errorf("WriteAutoCleaningInterval() sent 0x%v. Expected 0x80040009093a80a7", got)
+	}
+}
+
+func TestI2CCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(d *sps30.I2CDevice) error
+		want []string
+	}{
+		{name: "StopMeasurement", call: (*sps30.I2CDevice).StopMeasurement, want: []string{"0104"}},
+		{name: "StartFanCleaning", call: (*sps30.I2CDevice).StartFanCleaning, want: []string{"5607"}},
+		{name: "Sleep", call: (*sps30.I2CDevice).Sleep, want: []string{"1001"}},
+		{name: "Wakeup", call: (*sps30.I2CDevice).Wakeup, want: []string{"1103", "1103"}},
+	}
+	for _, test := range tests {
+		bus := &fakeI2CBus{}
+		device := sps30.NewI2C(bus)
+
+		if err := test.call(&device); err != nil {
+			t.Errorf("%s() failed: %v", test.name, err)
+		}
+		if len(bus.written) != len(test.want) {
+			t.Errorf("%s() made %d writes. Expected %d", test.name, len(bus.written), len(test.want))
+			continue
+		}
+		for i, w := range bus.written {
+			if got := hex.EncodeToString(w); got != test.want[i] {
+				t.Errorf("%s() write %d = 0x%v. Expected 0x%v", test.name, i, got, test.want[i])
+			}
+		}
+	}
+}
+
+func TestI2CCRCMismatch(t *testing.T) {
+	bus := &fakeI2CBus{responses: map[uint16][]byte{0xd100: {0x02, 0x03}}, corrupt: true}
+	device := sps30.NewI2C(bus)
+
+	err := device.ReadVersion(&sps30.VersionInfo{})
+	if !errors.Is(err, sps30.ErrCRCMismatch) {
+		t.Errorf("ReadVersion() error = %v. Expected %v", err, sps30.ErrCRCMismatch)
+	}
+}
@@ -0,0 +1,105 @@
+package sps30
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/MasandeM/sps30/shdlc"
+	"go.bug.st/serial"
+)
+
+// serial settings required by the SPS30 UART interface
+var serialMode = serial.Mode{
+	BaudRate: 115200,
+	DataBits: 8,
+	Parity:   serial.NoParity,
+	StopBits: serial.OneStopBit,
+}
+
+// openSerialPort opens a serial port, replaced in tests
+var openSerialPort = serial.Open
+
+// Open opens the serial port at path, e.g. "/dev/ttyUSB0", with the settings required by the SPS30
+// (115200 baud, 8 data bits, no parity, one stop bit) and returns a Device configured by opts.
+// With WithWakeup the device is woken up first, and with WithProbe Open fails unless an SPS30
+// answers a version request. Close the Device to stop measuring and release the port.
+func Open(path string, opts ...OpenOption) (*Device, error) {
+	port, err := openPort(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newOpenConfig(opts)
+	device := newDevice(port, new(sync.Mutex), shdlc.NewClient(port, 0), c.config)
+
+	if c.wakeup {
+		// a device that is already awake may not answer the wake-up command
+		err = device.Wakeup()
+		if err != nil {
+			device.logf("sps30: wake-up on %s failed: %v", path, err)
+		}
+	}
+
+	if c.probe {
+		version_info := VersionInfo{}
+		err = device.ReadVersion(&version_info)
+		if err != nil {
+			port.Close()
+			return nil, fmt.Errorf("no SPS30 responding on %s: %w", path, err)
+		}
+	}
+
+	return &device, nil
+}
+
+// openPort opens the serial port at path with the settings required by the SPS30
+func openPort(path string) (serial.Port, error) {
+	port, err := openSerialPort(path, &serialMode)
+	if err != nil {
+		return nil, fmt.Errorf("could not open serial port %s: %w", path, err)
+	}
+
+	err = port.SetReadTimeout(shdlc.PollInterval)
+	if err != nil {
+		port.Close()
+		return nil, fmt.Errorf("could not set read timeout on %s: %w", path, err)
+	}
+
+	return port, nil
+}
+
+// Close stops the measurement and closes the transport if it implements io.Closer. A device that was
+// not measuring rejects the stop command, which is not an error, and a device put to Sleep is left
+// asleep. The transport is closed even if the measurement could not be stopped. The transport of a
+// Device on a Bus is shared with other devices and is left open, close the Bus instead; the
+// measurement is only stopped if this Device started it.
+func (d *Device) Close() error {
+	var stop_err error
+
+	d.mu.Lock()
+	// a sleeping device has its UART switched off and would not answer
+	stop := (!d.shared || d.state.measuring) && !d.state.sleeping
+	d.mu.Unlock()
+
+	if stop {
+		stop_err = d.StopMeasurement()
+
+		var device_err *DeviceError
+		if errors.As(stop_err, &device_err) && device_err.State == stateCommandNotAllowed {
+			stop_err = nil
+		}
+	}
+
+	if d.shared {
+		return stop_err
+	}
+
+	var close_err error
+	if closer, ok := d.uart.(io.Closer); ok {
+		close_err = closer.Close()
+	}
+
+	return errors.Join(stop_err, close_err)
+}
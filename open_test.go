@@ -0,0 +1,161 @@
+package sps30_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MasandeM/sps30"
+	"github.com/MasandeM/sps30/simulator"
+	"go.bug.st/serial"
+)
+
+// stubSerialPort makes Open return port, recording the path and mode it was opened with
+func stubSerialPort(t *testing.T, port serial.Port) (*string, *serial.Mode) {
+	path := new(string)
+	mode := new(serial.Mode)
+
+	original := *sps30.OpenSerialPort
+	t.Cleanup(func() { *sps30.OpenSerialPort = original })
+
+	*sps30.OpenSerialPort = func(p string, m *serial.Mode) (serial.Port, error) {
+		*path = p
+		*mode = *m
+		return port, nil
+	}
+
+	return path, mode
+}
+
+func TestOpen(t *testing.T) {
+	mockUart := newScriptedUart(versionResponse, stopMeasurementResponse)
+	path, mode := stubSerialPort(t, mockUart)
+
+	device, err := sps30.Open("/dev/ttyUSB3", sps30.WithProbe())
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	if *path != "/dev/ttyUSB3" {
+		t.Errorf("Open() opened %q. Expected /dev/ttyUSB3", *path)
+	}
+	want := serial.Mode{BaudRate: 115200, DataBits: 8, Parity: serial.NoParity, StopBits: serial.OneStopBit}
+	if *mode != want {
+		t.Errorf("Open() used mode %+v. Expected %+v", *mode, want)
+	}
+	if got := hex.EncodeToString((*mockUart.written)[0]); got != "7e00d1002e7e" {
+		t.Errorf("Open() probed with 0x%v. Expected 0x7e00d1002e7e", got)
+	}
+
+	if err := device.Close(); err != nil {
+		t.Errorf("Close() failed: %v", err)
+	}
+	if got := hex.EncodeToString((*mockUart.written)[1]); got != "7e000100fe7e" {
+		t.Errorf("Close() sent 0x%v. Expected Stop Measurement 0x7e000100fe7e", got)
+	}
+	if *mockUart.closes != 1 {
+		t.Errorf("Close() closed the port %d times. Expected 1", *mockUart.closes)
+	}
+}
+
+func TestCloseIdleDevice(t *testing.T) {
+	// Command not allowed in current state, the device is already idle
+	mockUart := newScriptedUart([]byte{0x7e, 0x00, 0x01, 0x43, 0x00, 0xbb, 0x7e})
+	stubSerialPort(t, mockUart)
+
+	device, err := sps30.Open("/dev/ttyUSB0")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	if err := device.Close(); err != nil {
+		t.Errorf("Close() failed: %v", err)
+	}
+	if got := hex.EncodeToString((*mockUart.written)[0]); got != "7e000100fe7e" {
+		t.Errorf("Close() sent 0x%v. Expected Stop Measurement 0x7e000100fe7e", got)
+	}
+	if *mockUart.closes != 1 {
+		t.Errorf("Close() closed the port %d times. Expected 1", *mockUart.closes)
+	}
+}
+
+func TestOpenProbeFails(t *testing.T) {
+	mockUart := newScriptedUart()
+	stubSerialPort(t, mockUart)
+
+	_, err := sps30.Open("/dev/ttyUSB0", sps30.WithProbe())
+	if !errors.Is(err, sps30.ErrMissingStart) {
+		t.Errorf("Open() error = %v. Expected %v", err, sps30.ErrMissingStart)
+	}
+	if *mockUart.closes != 1 {
+		t.Errorf("Open() closed the port %d times after the probe failed. Expected 1", *mockUart.closes)
+	}
+}
+
+func TestOpenWakeup(t *testing.T) {
+	mockUart := newScriptedUart([]byte{0x7e, 0x00, 0x7d, 0x31, 0x00, 0x00, 0xee, 0x7e}, versionResponse)
+	stubSerialPort(t, mockUart)
+
+	_, err := sps30.Open("/dev/ttyUSB0", sps30.WithWakeup(), sps30.WithProbe())
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	want := []string{"ff", "7e007d3100ee7e", "7e00d1002e7e"}
+	for i, w := range *mockUart.written {
+		if got := hex.EncodeToString(w); got != want[i] {
+			t.Errorf("write %d = 0x%v. Expected 0x%v", i, got, want[i])
+		}
+	}
+}
+
+func TestCloseStopsMeasurement(t *testing.T) {
+	mockUart := newScriptedUart(startMeasurementResponse, stopMeasurementResponse)
+	stubSerialPort(t, mockUart)
+
+	device, err := sps30.Open("/dev/ttyUSB0")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if err := device.StartMeasurement(); err != nil {
+		t.Fatalf("StartMeasurement() failed: %v", err)
+	}
+
+	if err := device.Close(); err != nil {
+		t.Errorf("Close() failed: %v", err)
+	}
+	if got := hex.EncodeToString((*mockUart.written)[1]); got != "7e000100fe7e" {
+		t.Errorf("Close() sent 0x%v. Expected Stop Measurement 0x7e000100fe7e", got)
+	}
+	if *mockUart.closes != 1 {
+		t.Errorf("Close() closed the port %d times. Expected 1", *mockUart.closes)
+	}
+}
+
+func TestCloseSleepingDevice(t *testing.T) {
+	sim := simulator.New()
+	device := sps30.New(sim)
+
+	if err := device.StartMeasurement(); err != nil {
+		t.Fatalf("StartMeasurement() failed: %v", err)
+	}
+	if err := device.StopMeasurement(); err != nil {
+		t.Fatalf("StopMeasurement() failed: %v", err)
+	}
+	if err := device.Sleep(); err != nil {
+		t.Fatalf("Sleep() failed: %v", err)
+	}
+
+	// the UART of a sleeping device is off, so a stop command would go unanswered
+	start := time.Now()
+	if err := device.Close(); err != nil {
+		t.Errorf("Close() failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Close() took %v waiting for a sleeping device", elapsed)
+	}
+	if sim.Mode() != simulator.ModeSleep {
+		t.Errorf("Close() woke the device up")
+	}
+}
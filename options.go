@@ -0,0 +1,130 @@
+package sps30
+
+import "time"
+
+// Logger receives diagnostic messages from a Device, such as retried transactions.
+// *log.Logger satisfies Logger.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// Option configures a Device created by New, Open or a Bus
+type Option func(*config)
+
+type config struct {
+	addr    uint8
+	timeout time.Duration
+	retry   RetryPolicy
+	logger  Logger
+	format  OutputFormat
+}
+
+func newConfig(opts []Option) config {
+	c := config{
+		addr:    peripheralAddr,
+		timeout: shdlcResponseTimeout,
+		retry:   NoRetry,
+		format:  OutputFormatFloat,
+	}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return c
+}
+
+// OpenOption configures Open and Discover. Every Option is an OpenOption, while WithWakeup and
+// WithProbe are OpenOptions only, as they act on the port when it is opened.
+type OpenOption interface {
+	applyOpen(c *openConfig)
+}
+
+type openConfig struct {
+	config
+	wakeup bool
+	probe  bool
+}
+
+// openOption is an OpenOption that has no meaning for New
+type openOption func(*openConfig)
+
+func (o openOption) applyOpen(c *openConfig) {
+	o(c)
+}
+
+func (o Option) applyOpen(c *openConfig) {
+	o(&c.config)
+}
+
+func newOpenConfig(opts []OpenOption) openConfig {
+	c := openConfig{config: newConfig(nil)}
+
+	for _, opt := range opts {
+		opt.applyOpen(&c)
+	}
+
+	return c
+}
+
+// WithAddress sets the SHDLC slave address of the device, 0 by default like the SPS30 uses.
+// Devices at different addresses sharing a transport are created with a Bus instead.
+func WithAddress(addr uint8) Option {
+	return func(c *config) {
+		c.addr = addr
+	}
+}
+
+// WithResponseTimeout sets how long the device gets to respond to a command, 500ms by default.
+// Timeouts of 0 or less are ignored.
+func WithResponseTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		if timeout > 0 {
+			c.timeout = timeout
+		}
+	}
+}
+
+// WithRetryPolicy sets the policy used to retry failed transactions, NoRetry by default
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *config) {
+		c.retry = policy
+	}
+}
+
+// WithLogger sets the Logger receiving diagnostic messages, which are discarded by default
+func WithLogger(logger Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+// WithMeasurementFormat sets the format StartMeasurement requests, OutputFormatFloat by default.
+// It is also the format ReadMeasurement expects until a measurement is started, for a device
+// that was already measuring when the Device was created.
+func WithMeasurementFormat(format OutputFormat) Option {
+	return func(c *config) {
+		c.format = format
+	}
+}
+
+// WithWakeup makes Open wake the device up, in case it was left in Sleep-mode
+func WithWakeup() OpenOption {
+	return openOption(func(c *openConfig) {
+		c.wakeup = true
+	})
+}
+
+// WithProbe makes Open read the device's version, failing if no SPS30 responds on the port
+func WithProbe() OpenOption {
+	return openOption(func(c *openConfig) {
+		c.probe = true
+	})
+}
+
+// logf passes a diagnostic message to the device's Logger, if any
+func (d *Device) logf(format string, v ...any) {
+	if d.logger != nil {
+		d.logger.Printf(format, v...)
+	}
+}
@@ -0,0 +1,88 @@
+package sps30_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MasandeM/sps30"
+)
+
+type bufferLogger struct {
+	buf *bytes.Buffer
+}
+
+func (l bufferLogger) Printf(format string, v ...any) {
+	fmt.Fprintf(l.buf, format+"\n", v...)
+}
+
+func TestWithAddress(t *testing.T) {
+	mockUart := newScriptedUart([]byte{0x7e, 0x05, 0xd1, 0x00, 0x07, 0x02, 0x03, 0x00, 0x07, 0x00, 0x02, 0x00, 0x14, 0x7e})
+	device := sps30.New(mockUart, sps30.WithAddress(5))
+
+	if err := device.ReadVersion(&sps30.VersionInfo{}); err != nil {
+		t.Fatalf("ReadVersion() failed: %v", err)
+	}
+	if got := hex.EncodeToString((*mockUart.written)[0]); got != "7e05d100297e" {
+		t.Errorf("ReadVersion() sent 0x%v. Expected 0x7e05d100297e", got)
+	}
+}
+
+func TestWithResponseTimeout(t *testing.T) {
+	device := sps30.New(newDribbleUart(nil), sps30.WithResponseTimeout(20*time.Millisecond))
+
+	start := time.Now()
+	err := device.StopMeasurement()
+
+	if !errors.Is(err, sps30.ErrMissingStart) {
+		t.Errorf("StopMeasurement() error = %v. Expected %v", err, sps30.ErrMissingStart)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("StopMeasurement() returned after %v. Expected to time out after 20ms", elapsed)
+	}
+}
+
+func TestWithRetryPolicyAndLogger(t *testing.T) {
+	logs := new(bytes.Buffer)
+	mockUart := newScriptedUart(corruptVersionResponse, versionResponse)
+	device := sps30.New(mockUart,
+		sps30.WithRetryPolicy(sps30.DefaultRetryPolicy),
+		sps30.WithLogger(bufferLogger{buf: logs}),
+	)
+
+	if err := device.ReadVersion(&sps30.VersionInfo{}); err != nil {
+		t.Fatalf("ReadVersion() failed: %v", err)
+	}
+	if got := device.Stats().Retries; got != 1 {
+		t.Errorf("Stats().Retries = %d. Expected 1", got)
+	}
+	if !bytes.Contains(logs.Bytes(), []byte("mismatch in CRC")) {
+		t.Errorf("logged %q. Expected the retried CRC mismatch", logs.String())
+	}
+}
+
+func TestWithMeasurementFormat(t *testing.T) {
+	mockUart := newScriptedUart(
+		[]byte{0x7e, 0x00, 0x00, 0x00, 0x00, 0xff, 0x7e},
+		[]byte{0x7e, 0x00, 0x03, 0x00, 0x14, 0x00, 0x0c, 0x00, 0x0f, 0x00, 0x7d, 0x31, 0x00, 0x12, 0x00, 0x50, 0x00, 0x5f, 0x00, 0x61, 0x00, 0x62, 0x00, 0x62, 0x02, 0x08, 0xcc, 0x7e},
+	)
+	device := sps30.New(mockUart, sps30.WithMeasurementFormat(sps30.OutputFormatUint16))
+
+	if err := device.StartMeasurement(); err != nil {
+		t.Fatalf("StartMeasurement() failed: %v", err)
+	}
+	if got := hex.EncodeToString((*mockUart.written)[0]); got != "7e0000020105f77e" {
+		t.Errorf("StartMeasurement() sent 0x%v. Expected 0x7e0000020105f77e", got)
+	}
+
+	measurement := sps30.Measurement{}
+	if err := device.ReadMeasurement(&measurement); err != nil {
+		t.Fatalf("ReadMeasurement() failed: %v", err)
+	}
+	if measurement.Mc1p0 != 12 {
+		t.Errorf("ReadMeasurement() Mc1p0 = %v. Expected 12", measurement.Mc1p0)
+	}
+}
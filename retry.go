@@ -0,0 +1,114 @@
+package sps30
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy controls how a Device retries SHDLC transactions that failed because of a transient error.
+//
+// A retry sends the request again, so a command whose response was lost may be executed twice; the
+// device answers a repeated StartMeasurement or StopMeasurement with "Command not allowed in current state".
+// Responses with a non-zero device state are never retried, as repeating the command yields the same state.
+type RetryPolicy struct {
+	// Attempts is the total number of tries per transaction, including the first. Values below 1 mean 1.
+	Attempts int
+	// Backoff is the time waited before the first retry, doubling for every further retry.
+	Backoff time.Duration
+	// Retryable reports whether a failed transaction may be retried. If nil, IsTransient is used.
+	Retryable func(err error) bool
+}
+
+// NoRetry is the RetryPolicy of a new Device, trying each transaction once
+var NoRetry = RetryPolicy{Attempts: 1}
+
+// DefaultRetryPolicy retries transient errors twice, after 10ms and 20ms
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, Backoff: 10 * time.Millisecond, Retryable: IsTransient}
+
+// IsTransient reports whether err is caused by a frame corrupted or lost on the line, such as a
+// CRC mismatch, missing start or stop byte, or a stale response to an earlier command.
+// Context errors and errors writing to the transport are not transient.
+func IsTransient(err error) bool {
+	return errors.Is(err, ErrCRCMismatch) ||
+		errors.Is(err, ErrMissingStart) ||
+		errors.Is(err, ErrMissingStop) ||
+		errors.Is(err, ErrFrameTooLong) ||
+		errors.Is(err, ErrInvalidFrame) ||
+		errors.Is(err, ErrAddressMismatch) ||
+		errors.Is(err, ErrCommandMismatch)
+}
+
+// Stats holds counters for diagnosing the connection to a Device
+type Stats struct {
+	Transactions uint64 // transactions started
+	Retries      uint64 // retries after a transient error
+	Failures     uint64 // transactions that failed after their last attempt
+}
+
+type deviceStats struct {
+	transactions atomic.Uint64
+	retries      atomic.Uint64
+	failures     atomic.Uint64
+}
+
+// SetRetryPolicy sets the policy used to retry failed transactions
+func (d *Device) SetRetryPolicy(policy RetryPolicy) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.state.retry = policy
+}
+
+// Stats returns the transaction counters of the device
+func (d *Device) Stats() Stats {
+	return Stats{
+		Transactions: d.stats.transactions.Load(),
+		Retries:      d.stats.retries.Load(),
+		Failures:     d.stats.failures.Load(),
+	}
+}
+
+// withRetry runs transaction according to the device's RetryPolicy. The caller must hold d.mu.
+func (d *Device) withRetry(ctx context.Context, transaction func() error) error {
+	d.stats.transactions.Add(1)
+
+	retryable := d.state.retry.Retryable
+	if retryable == nil {
+		retryable = IsTransient
+	}
+	backoff := d.state.retry.Backoff
+
+	for attempt := 1; ; attempt++ {
+		err := transaction()
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= d.state.retry.Attempts || ctx.Err() != nil || !retryable(err) {
+			d.stats.failures.Add(1)
+			d.logf("sps30: transaction failed on attempt %d: %v", attempt, err)
+			return err
+		}
+
+		d.logf("sps30: retrying transaction after attempt %d failed: %v", attempt, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			d.stats.failures.Add(1)
+			return fmt.Errorf("%w (retry interrupted: %w)", err, ctx.Err())
+		}
+		backoff *= 2
+
+		// drop what is left of the failed response so it is not read as the next one
+		if reset_err := d.resetInputBuffer(); reset_err != nil {
+			d.stats.failures.Add(1)
+			return fmt.Errorf("%w (failed to reset UART input buffer: %v)", err, reset_err)
+		}
+
+		d.stats.retries.Add(1)
+	}
+}
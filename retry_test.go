@@ -0,0 +1,117 @@
+package sps30_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/MasandeM/sps30"
+)
+
+var corruptVersionResponse = []byte{0x7e, 0x00, 0xd1, 0x00, 0x07, 0x02, 0x03, 0x00, 0x07, 0x00, 0x02, 0x00, 0x18, 0x7e}
+var versionResponse = []byte{0x7e, 0x00, 0xd1, 0x00, 0x07, 0x02, 0x03, 0x00, 0x07, 0x00, 0x02, 0x00, 0x19, 0x7e}
+
+func TestRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    sps30.RetryPolicy
+		frames    [][]byte
+		wantErr   error
+		wantTx    int
+		wantStats sps30.Stats
+	}{
+		{
+			name:      "no retry by default",
+			policy:    sps30.NoRetry,
+			frames:    [][]byte{corruptVersionResponse, versionResponse},
+			wantErr:   sps30.ErrCRCMismatch,
+			wantTx:    1,
+			wantStats: sps30.Stats{Transactions: 1, Failures: 1},
+		},
+		{
+			name:      "retry after CRC mismatch",
+			policy:    sps30.DefaultRetryPolicy,
+			frames:    [][]byte{corruptVersionResponse, versionResponse},
+			wantTx:    2,
+			wantStats: sps30.Stats{Transactions: 1, Retries: 1},
+		},
+		{
+			name:      "retry after stale response",
+			policy:    sps30.DefaultRetryPolicy,
+			frames:    [][]byte{{0x7e, 0x00, 0x01, 0x00, 0x00, 0xfe, 0x7e}, versionResponse},
+			wantTx:    2,
+			wantStats: sps30.Stats{Transactions: 1, Retries: 1},
+		},
+		{
+			name:      "attempts exhausted",
+			policy:    sps30.DefaultRetryPolicy,
+			frames:    [][]byte{corruptVersionResponse, corruptVersionResponse, corruptVersionResponse, versionResponse},
+			wantErr:   sps30.ErrCRCMismatch,
+			wantTx:    3,
+			wantStats: sps30.Stats{Transactions: 1, Retries: 2, Failures: 1},
+		},
+		{
+			name:      "custom retryable",
+			policy:    sps30.RetryPolicy{Attempts: 3, Retryable: func(err error) bool { return false }},
+			frames:    [][]byte{corruptVersionResponse, versionResponse},
+			wantErr:   sps30.ErrCRCMismatch,
+			wantTx:    1,
+			wantStats: sps30.Stats{Transactions: 1, Failures: 1},
+		},
+		{
+			name:      "device state is never retried",
+			policy:    sps30.RetryPolicy{Attempts: 3, Retryable: func(err error) bool { return true }},
+			frames:    [][]byte{{0x7e, 0x00, 0xd1, 0x02, 0x00, 0x2c, 0x7e}, versionResponse},
+			wantErr:   &sps30.DeviceError{},
+			wantTx:    1,
+			wantStats: sps30.Stats{Transactions: 1},
+		},
+	}
+
+	for _, test := range tests {
+		mockUart := newScriptedUart(test.frames...)
+		device := sps30.New(mockUart)
+		device.SetRetryPolicy(test.policy)
+
+		err := device.ReadVersion(&sps30.VersionInfo{})
+
+		var deviceErr *sps30.DeviceError
+		switch {
+		case test.wantErr == nil && err != nil:
+			t.Errorf("%s: ReadVersion() failed: %v", test.name, err)
+		case errors.As(test.wantErr, &deviceErr):
+			if !errors.As(err, &deviceErr) {
+				t.Errorf("%s: ReadVersion() error = %v. Expected a DeviceError", test.name, err)
+			}
+		case test.wantErr != nil && !errors.Is(err, test.wantErr):
+			t.Errorf("%s: ReadVersion() error = %v. Expected %v", test.name, err, test.wantErr)
+		}
+
+		if len(*mockUart.written) != test.wantTx {
+			t.Errorf("%s: ReadVersion() sent %d requests. Expected %d", test.name, len(*mockUart.written), test.wantTx)
+		}
+		if got := device.Stats(); got != test.wantStats {
+			t.Errorf("%s: Stats() = %+v. Expected %+v", test.name, got, test.wantStats)
+		}
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: sps30.ErrCRCMismatch, want: true},
+		{err: sps30.ErrMissingStart, want: true},
+		{err: sps30.ErrMissingStop, want: true},
+		{err: sps30.ErrFrameTooLong, want: true},
+		{err: sps30.ErrCommandMismatch, want: true},
+		{err: sps30.ErrAddressMismatch, want: true},
+		{err: &sps30.DeviceError{Cmd: 0x03, State: 2}, want: false},
+		{err: errors.New("write failed"), want: false},
+	}
+	for _, test := range tests {
+		if got := sps30.IsTransient(test.err); got != test.want {
+			t.Errorf("IsTransient(%v) = %v. Expected %v", test.err, got, test.want)
+		}
+	}
+}
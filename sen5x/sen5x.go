@@ -0,0 +1,361 @@
+// Package sen5x is a driver for the Sensirion SEN54 and SEN55 environmental sensor nodes connected over UART.
+//
+// The SEN5x speaks the same SHDLC protocol as the SPS30 at 115200 baud, 8N1, and measures particulate matter,
+// relative humidity, temperature and a VOC index, and the SEN55 also an NOx index.
+package sen5x
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/MasandeM/sps30/shdlc"
+)
+
+const cmdStartMeasurement = 0x00
+const cmdStopMeasurement = 0x01
+const cmdReadDataReady = 0x02
+const cmdReadMeasuredValues = 0x03
+const cmdStartFanCleaning = 0x56
+const cmdDeviceInfo = 0xd0
+const cmdReadVersion = 0xd1
+const cmdReset = 0xd3
+
+const subcmdStartMeasurement = 0x01
+const subcmdReadDataReady = 0x00
+const subcmdMeasuredValues = 0x04
+
+const deviceInfoProductName = 0x01
+const deviceInfoSerialNumber = 0x03
+const deviceInfoMaxLen = 32
+
+const measuredValuesLen = 16
+
+// values the device reports for signals it can't measure (yet)
+const unknownUint16 = 0xffff
+const unknownInt16 = 0x7fff
+
+// time the device needs to boot after a reset before it accepts new commands
+const resetBootTime = 100 * time.Millisecond
+
+// VersionInfo holds information about the firmware, hardware, and SHDLC protocol
+type VersionInfo struct {
+	FirmwareMajor uint8
+	FirmwareMinor uint8
+	HardwareMajor uint8
+	HardwareMinor uint8
+	ProtocolMajor uint8
+	ProtocolMinor uint8
+	FirmwareDebug bool
+}
+
+// Measurement holds the values measured by a SEN5x.
+// Mc refers to the mass concentration of particulate matter up to the given size in µg/m³.
+// Humidity is the ambient relative humidity in %RH and Temperature the ambient temperature in °C.
+// The VOC and NOx indices range from 1 to 500, with 100 and 1 respectively being the average conditions.
+// Values the device doesn't measure, like the NOx index of a SEN54, or hasn't measured yet are NaN.
+type Measurement struct {
+	Mc1p0       float32
+	Mc2p5       float32
+	Mc4p0       float32
+	Mc10p0      float32
+	Humidity    float32
+	Temperature float32
+	VOCIndex    float32
+	NOxIndex    float32
+}
+
+// Device represents a SEN5x device.
+// A Device is safe for concurrent use by multiple goroutines, commands are executed one after the other.
+// Commands the device rejects return a *shdlc.StateError.
+type Device struct {
+	mu      *sync.Mutex
+	client  *shdlc.Client
+	addr    uint8
+	timeout time.Duration
+}
+
+// Option configures a Device created by New
+type Option func(*config)
+
+type config struct {
+	addr    uint8
+	timeout time.Duration
+}
+
+// WithAddress sets the SHDLC address of the device, 0 by default
+func WithAddress(addr uint8) Option {
+	return func(c *config) {
+		c.addr = addr
+	}
+}
+
+// WithResponseTimeout sets how long to wait for a response, shdlc.DefaultTimeout by default
+func WithResponseTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.timeout = timeout
+	}
+}
+
+// New creates a new SEN5x Device communicating over transport, such as a serial.Port opened with
+// 115200 baud, 8 data bits, no parity and one stop bit. See shdlc.Client for the capabilities used.
+func New(transport io.ReadWriter, opts ...Option) Device {
+	return NewShared(shdlc.NewClient(transport, 0), new(sync.Mutex), opts...)
+}
+
+// NewShared creates a new SEN5x Device communicating through client, which it shares with other
+// devices on the same transport, e.g. the client of an sps30.Bus. Every command holds mu from sending
+// its request until its response has been received, so mu must be held by all users of client.
+func NewShared(client *shdlc.Client, mu *sync.Mutex, opts ...Option) Device {
+	c := config{timeout: shdlc.DefaultTimeout}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return Device{
+		mu:      mu,
+		client:  client,
+		addr:    c.addr,
+		timeout: c.timeout,
+	}
+}
+
+// StartMeasurement starts a continuous measurement of all signals
+func (d *Device) StartMeasurement() error {
+	return d.StartMeasurementContext(context.Background())
+}
+
+// StartMeasurementContext is like StartMeasurement but gives up once ctx is done.
+func (d *Device) StartMeasurementContext(ctx context.Context) error {
+	_, err := d.execute(ctx, cmdStartMeasurement, []byte{subcmdStartMeasurement}, 0)
+	if err != nil {
+		return fmt.Errorf("could not start measurement: %w", err)
+	}
+
+	return nil
+}
+
+// StopMeasurement stops the measurement and returns the device to Idle-mode
+func (d *Device) StopMeasurement() error {
+	return d.StopMeasurementContext(context.Background())
+}
+
+// StopMeasurementContext is like StopMeasurement but gives up once ctx is done.
+func (d *Device) StopMeasurementContext(ctx context.Context) error {
+	_, err := d.execute(ctx, cmdStopMeasurement, nil, 0)
+	if err != nil {
+		return fmt.Errorf("could not stop measurement: %w", err)
+	}
+
+	return nil
+}
+
+// DataReady reports whether new measured values are available to be read
+func (d *Device) DataReady() (bool, error) {
+	return d.DataReadyContext(context.Background())
+}
+
+// DataReadyContext is like DataReady but gives up once ctx is done.
+func (d *Device) DataReadyContext(ctx context.Context) (bool, error) {
+	data, err := d.execute(ctx, cmdReadDataReady, []byte{subcmdReadDataReady}, 2)
+	if err != nil {
+		return false, fmt.Errorf("could not read data-ready flag from device: %w", err)
+	}
+
+	return data[1] == 0x01, nil
+}
+
+// ReadMeasurement reads the latest measured values, which clears the data-ready flag.
+// The device must be measuring.
+func (d *Device) ReadMeasurement(measurement *Measurement) error {
+	return d.ReadMeasurementContext(context.Background(), measurement)
+}
+
+// ReadMeasurementContext is like ReadMeasurement but gives up once ctx is done.
+func (d *Device) ReadMeasurementContext(ctx context.Context, measurement *Measurement) error {
+	data, err := d.execute(ctx, cmdReadMeasuredValues, []byte{subcmdMeasuredValues}, measuredValuesLen)
+	if err != nil {
+		return fmt.Errorf("could not read measurement from device: %w", err)
+	}
+
+	decodeMeasurement(data, measurement)
+
+	return nil
+}
+
+// StartFanCleaning starts the fan-cleaning manually. The device must be measuring.
+func (d *Device) StartFanCleaning() error {
+	return d.StartFanCleaningContext(context.Background())
+}
+
+// StartFanCleaningContext is like StartFanCleaning but gives up once ctx is done.
+func (d *Device) StartFanCleaningContext(ctx context.Context) error {
+	_, err := d.execute(ctx, cmdStartFanCleaning, nil, 0)
+	if err != nil {
+		return fmt.Errorf("could not start fan cleaning: %w", err)
+	}
+
+	return nil
+}
+
+// ReadProductName reads the product name, e.g. "SEN55"
+func (d *Device) ReadProductName() (string, error) {
+	return d.ReadProductNameContext(context.Background())
+}
+
+// ReadProductNameContext is like ReadProductName but gives up once ctx is done.
+func (d *Device) ReadProductNameContext(ctx context.Context) (string, error) {
+	name, err := d.readDeviceInfoString(ctx, deviceInfoProductName)
+	if err != nil {
+		return "", fmt.Errorf("could not read product name from device: %w", err)
+	}
+
+	return name, nil
+}
+
+// ReadSerialNumber reads the serial number of the device
+func (d *Device) ReadSerialNumber() (string, error) {
+	return d.ReadSerialNumberContext(context.Background())
+}
+
+// ReadSerialNumberContext is like ReadSerialNumber but gives up once ctx is done.
+func (d *Device) ReadSerialNumberContext(ctx context.Context) (string, error) {
+	serial_number, err := d.readDeviceInfoString(ctx, deviceInfoSerialNumber)
+	if err != nil {
+		return "", fmt.Errorf("could not read serial number from device: %w", err)
+	}
+
+	return serial_number, nil
+}
+
+// ReadVersion populates a VersionInfo struct
+func (d *Device) ReadVersion(version_info *VersionInfo) error {
+	return d.ReadVersionContext(context.Background(), version_info)
+}
+
+// ReadVersionContext is like ReadVersion but gives up once ctx is done.
+func (d *Device) ReadVersionContext(ctx context.Context, version_info *VersionInfo) error {
+	data, err := d.execute(ctx, cmdReadVersion, nil, 7)
+	if err != nil {
+		return fmt.Errorf("could not read version info from device: %w", err)
+	}
+
+	version_info.FirmwareMajor = data[0]
+	version_info.FirmwareMinor = data[1]
+	version_info.FirmwareDebug = data[2] != 0
+	version_info.HardwareMajor = data[3]
+	version_info.HardwareMinor = data[4]
+	version_info.ProtocolMajor = data[5]
+	version_info.ProtocolMinor = data[6]
+
+	return nil
+}
+
+// Reset performs a soft reset of the device and waits for it to boot
+func (d *Device) Reset() error {
+	return d.ResetContext(context.Background())
+}
+
+// ResetContext is like Reset but gives up once ctx is done.
+func (d *Device) ResetContext(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.executeLocked(ctx, cmdReset, nil, 0)
+	if err != nil {
+		return fmt.Errorf("could not reset device: %w", err)
+	}
+
+	select {
+	case <-time.After(resetBootTime):
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for device to boot: %w", ctx.Err())
+	}
+
+	return d.client.ResetInput()
+}
+
+// readDeviceInfoString reads a null-terminated string of the device information
+func (d *Device) readDeviceInfoString(ctx context.Context, subcmd uint8) (string, error) {
+	data, err := d.execute(ctx, cmdDeviceInfo, []byte{subcmd}, -1)
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) > deviceInfoMaxLen {
+		return "", fmt.Errorf("device info of %d bytes exceeds %d bytes", len(data), deviceInfoMaxLen)
+	}
+
+	for i, b := range data {
+		if b == 0 {
+			return string(data[:i]), nil
+		}
+	}
+
+	return string(data), nil
+}
+
+// execute sends a command to the device and returns the data of its response,
+// which must be data_len bytes long unless data_len is negative
+func (d *Device) execute(ctx context.Context, cmd uint8, args []byte, data_len int) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.executeLocked(ctx, cmd, args, data_len)
+}
+
+// executeLocked is execute for callers holding the device's lock
+func (d *Device) executeLocked(ctx context.Context, cmd uint8, args []byte, data_len int) ([]byte, error) {
+	// a shared client may be set up to wait for responses of other devices differently
+	d.client.SetTimeout(d.timeout)
+
+	response, err := d.client.Transceive(ctx, shdlc.Request{Addr: d.addr, Cmd: cmd, Data: args})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := response.Err(); err != nil {
+		return nil, err
+	}
+
+	if data_len >= 0 && len(response.Data) != data_len {
+		return nil, fmt.Errorf("received %d bytes of data from device, expected %d", len(response.Data), data_len)
+	}
+
+	return response.Data, nil
+}
+
+// decodeMeasurement scales the measured values to their units
+func decodeMeasurement(data []byte, measurement *Measurement) {
+	measurement.Mc1p0 = scaledUint16(data[0:2], 10)
+	measurement.Mc2p5 = scaledUint16(data[2:4], 10)
+	measurement.Mc4p0 = scaledUint16(data[4:6], 10)
+	measurement.Mc10p0 = scaledUint16(data[6:8], 10)
+	measurement.Humidity = scaledInt16(data[8:10], 100)
+	measurement.Temperature = scaledInt16(data[10:12], 200)
+	measurement.VOCIndex = scaledInt16(data[12:14], 10)
+	measurement.NOxIndex = scaledInt16(data[14:16], 10)
+}
+
+// scaledUint16 divides a big-endian uint16 by factor, or returns NaN for an unknown value
+func scaledUint16(bytes []byte, factor float32) float32 {
+	value := binary.BigEndian.Uint16(bytes)
+	if value == unknownUint16 {
+		return float32(math.NaN())
+	}
+	return float32(value) / factor
+}
+
+// scaledInt16 divides a big-endian int16 by factor, or returns NaN for an unknown value
+func scaledInt16(bytes []byte, factor float32) float32 {
+	value := int16(binary.BigEndian.Uint16(bytes))
+	if value == unknownInt16 {
+		return float32(math.NaN())
+	}
+	return float32(value) / factor
+}
@@ -0,0 +1,214 @@
+package sen5x_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/MasandeM/sps30/sen5x"
+	"github.com/MasandeM/sps30/shdlc"
+	"github.com/MasandeM/sps30/simulator"
+)
+
+func newDevice(t *testing.T, opts ...simulator.Option) (sen5x.Device, *simulator.Simulator) {
+	sim := simulator.New(opts...)
+	t.Cleanup(func() { sim.Close() })
+
+	return sen5x.New(sim, sen5x.WithResponseTimeout(50*time.Millisecond)), sim
+}
+
+func TestDeviceInfo(t *testing.T) {
+	device, _ := newDevice(t, simulator.WithModel(simulator.ModelSEN55), simulator.WithFirmware(2, 0))
+
+	name, err := device.ReadProductName()
+	if err != nil {
+		t.Fatalf("ReadProductName() failed: %v", err)
+	}
+	if name != "SEN55" {
+		t.Errorf("ReadProductName() = %q. Expected SEN55", name)
+	}
+
+	serial_number, err := device.ReadSerialNumber()
+	if err != nil {
+		t.Fatalf("ReadSerialNumber() failed: %v", err)
+	}
+	if serial_number == "" {
+		t.Errorf("ReadSerialNumber() returned an empty serial number")
+	}
+
+	version := sen5x.VersionInfo{}
+	if err := device.ReadVersion(&version); err != nil {
+		t.Fatalf("ReadVersion() failed: %v", err)
+	}
+	if version.FirmwareMajor != 2 || version.FirmwareMinor != 0 || version.ProtocolMajor != 2 {
+		t.Errorf("ReadVersion() = %+v. Expected firmware 2.0 and protocol 2.0", version)
+	}
+}
+
+func TestReadMeasurement(t *testing.T) {
+	tests := []struct {
+		model  simulator.Model
+		values simulator.Values
+		want   sen5x.Measurement
+	}{
+		{
+			model: simulator.ModelSEN55,
+			values: simulator.Values{
+				Mc1p0: 3.1, Mc2p5: 4.7, Mc4p0: 5.2, Mc10p0: 5.5,
+				Humidity: 41.57, Temperature: 23.125, VOCIndex: 112, NOxIndex: 1.5,
+			},
+			want: sen5x.Measurement{
+				Mc1p0: 3.1, Mc2p5: 4.7, Mc4p0: 5.2, Mc10p0: 5.5,
+				Humidity: 41.57, Temperature: 23.125, VOCIndex: 112, NOxIndex: 1.5,
+			},
+		},
+		{ // freezing, and the SEN54 has no NOx sensor
+			model: simulator.ModelSEN54,
+			values: simulator.Values{
+				Mc1p0: 0, Mc2p5: 0.1, Mc4p0: 0.2, Mc10p0: 0.3,
+				Humidity: 88, Temperature: -12.5, VOCIndex: 1, NOxIndex: 1,
+			},
+			want: sen5x.Measurement{
+				Mc1p0: 0, Mc2p5: 0.1, Mc4p0: 0.2, Mc10p0: 0.3,
+				Humidity: 88, Temperature: -12.5, VOCIndex: 1, NOxIndex: float32(math.NaN()),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		clock := new(simulator.ManualClock)
+		device, _ := newDevice(t,
+			simulator.WithModel(test.model),
+			simulator.WithClock(clock.Now),
+			simulator.WithSeries(simulator.Constant(test.values)))
+
+		if err := device.StartMeasurement(); err != nil {
+			t.Fatalf("%v: StartMeasurement() failed: %v", test.model, err)
+		}
+		clock.Advance(time.Second)
+
+		got := sen5x.Measurement{}
+		if err := device.ReadMeasurement(&got); err != nil {
+			t.Fatalf("%v: ReadMeasurement() failed: %v", test.model, err)
+		}
+
+		if !equal(got, test.want) {
+			t.Errorf("%v: ReadMeasurement() = %+v. Expected %+v", test.model, got, test.want)
+		}
+	}
+}
+
+func TestReadMeasurementUnknown(t *testing.T) {
+	clock := new(simulator.ManualClock)
+	device, _ := newDevice(t, simulator.WithModel(simulator.ModelSEN55), simulator.WithClock(clock.Now))
+
+	if err := device.StartMeasurement(); err != nil {
+		t.Fatalf("StartMeasurement() failed: %v", err)
+	}
+
+	ready, err := device.DataReady()
+	if err != nil {
+		t.Fatalf("DataReady() failed: %v", err)
+	}
+	if ready {
+		t.Errorf("DataReady() = true before the first measurement")
+	}
+
+	got := sen5x.Measurement{}
+	if err := device.ReadMeasurement(&got); err != nil {
+		t.Fatalf("ReadMeasurement() failed: %v", err)
+	}
+
+	nan := float32(math.NaN())
+	want := sen5x.Measurement{Mc1p0: nan, Mc2p5: nan, Mc4p0: nan, Mc10p0: nan, Humidity: nan, Temperature: nan, VOCIndex: nan, NOxIndex: nan}
+	if !equal(got, want) {
+		t.Errorf("ReadMeasurement() before the first measurement = %+v. Expected all values unknown", got)
+	}
+
+	clock.Advance(time.Second)
+
+	ready, err = device.DataReady()
+	if err != nil {
+		t.Fatalf("DataReady() failed: %v", err)
+	}
+	if !ready {
+		t.Errorf("DataReady() = false after the first measurement")
+	}
+}
+
+func TestCommandNotAllowed(t *testing.T) {
+	device, sim := newDevice(t, simulator.WithModel(simulator.ModelSEN55))
+
+	var state_err *shdlc.StateError
+
+	err := device.ReadMeasurement(&sen5x.Measurement{})
+	if !errors.As(err, &state_err) || state_err.State != 0x43 {
+		t.Errorf("ReadMeasurement() in Idle-mode error = %v. Expected state 0x43", err)
+	}
+
+	if err := device.StartMeasurement(); err != nil {
+		t.Fatalf("StartMeasurement() failed: %v", err)
+	}
+	if err := device.StartFanCleaning(); err != nil {
+		t.Errorf("StartFanCleaning() failed: %v", err)
+	}
+	if err := device.StopMeasurement(); err != nil {
+		t.Fatalf("StopMeasurement() failed: %v", err)
+	}
+	if sim.Mode() != simulator.ModeIdle {
+		t.Errorf("Mode() = %v after StopMeasurement(). Expected Idle", sim.Mode())
+	}
+
+	err = device.StopMeasurement()
+	if !errors.As(err, &state_err) || state_err.State != 0x43 {
+		t.Errorf("StopMeasurement() in Idle-mode error = %v. Expected state 0x43", err)
+	}
+}
+
+func TestReset(t *testing.T) {
+	device, sim := newDevice(t, simulator.WithModel(simulator.ModelSEN54))
+
+	if err := device.StartMeasurement(); err != nil {
+		t.Fatalf("StartMeasurement() failed: %v", err)
+	}
+	if err := device.Reset(); err != nil {
+		t.Fatalf("Reset() failed: %v", err)
+	}
+	if sim.Mode() != simulator.ModeIdle {
+		t.Errorf("Mode() = %v after Reset(). Expected Idle", sim.Mode())
+	}
+}
+
+func TestAddress(t *testing.T) {
+	sim := simulator.New(simulator.WithModel(simulator.ModelSEN55), simulator.WithAddress(4))
+	defer sim.Close()
+
+	device := sen5x.New(sim, sen5x.WithAddress(4), sen5x.WithResponseTimeout(50*time.Millisecond))
+	if _, err := device.ReadProductName(); err != nil {
+		t.Errorf("ReadProductName() at address 4 failed: %v", err)
+	}
+}
+
+// equal compares measurements, treating NaN as equal to NaN and allowing for float32 rounding
+func equal(a, b sen5x.Measurement) bool {
+	pairs := [][2]float32{
+		{a.Mc1p0, b.Mc1p0}, {a.Mc2p5, b.Mc2p5}, {a.Mc4p0, b.Mc4p0}, {a.Mc10p0, b.Mc10p0},
+		{a.Humidity, b.Humidity}, {a.Temperature, b.Temperature}, {a.VOCIndex, b.VOCIndex}, {a.NOxIndex, b.NOxIndex},
+	}
+
+	for _, pair := range pairs {
+		x, y := float64(pair[0]), float64(pair[1])
+		if math.IsNaN(x) || math.IsNaN(y) {
+			if math.IsNaN(x) != math.IsNaN(y) {
+				return false
+			}
+			continue
+		}
+		if math.Abs(x-y) > 1e-4 {
+			return false
+		}
+	}
+
+	return true
+}
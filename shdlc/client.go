@@ -0,0 +1,212 @@
+package shdlc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// DefaultTimeout is the default time a Client waits for a complete response frame
+const DefaultTimeout = 500 * time.Millisecond
+
+// PollInterval is the longest time a single read of the Client blocks, bounding how long a cancelled
+// context goes unnoticed. Transports with a fixed read timeout, like a serial port, should use it.
+const PollInterval = 50 * time.Millisecond
+
+// Errors returned when a well-formed frame is not the response to the request that was sent
+var (
+	ErrAddressMismatch = errors.New("rx frame address does not match the request")
+	ErrCommandMismatch = errors.New("rx frame command does not match the request")
+)
+
+// ReadTimeoutSetter is implemented by transports that bound each Read with a timeout, like serial.Port.
+// A Read that times out returns 0 bytes and no error.
+type ReadTimeoutSetter interface {
+	SetReadTimeout(t time.Duration) error
+}
+
+// ReadDeadlineSetter is implemented by transports that bound reads with a deadline, like net.Conn.
+// A Read that passes the deadline returns an error with a Timeout() method reporting true.
+type ReadDeadlineSetter interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// InputBufferResetter is implemented by transports that can discard received data that has not been read yet.
+type InputBufferResetter interface {
+	ResetInputBuffer() error
+}
+
+// Client is the master of SHDLC devices connected to a transport, such as a serial port.
+//
+// The transport may implement ReadTimeoutSetter or ReadDeadlineSetter so the Client can give up
+// waiting for a response, and InputBufferResetter so stale input can be discarded. Without either
+// timeout capability a Read blocks until data arrives, and timeouts and cancellation are only noticed
+// between reads.
+//
+// A Client is not safe for concurrent use. Requests to devices sharing a transport must be serialized
+// by the caller, so each response is read by the Client waiting for it.
+type Client struct {
+	transport io.ReadWriter
+	reader    *Reader
+	timeout   time.Duration
+}
+
+// NewClient creates a Client on transport that waits up to timeout for each response, or DefaultTimeout if timeout is 0
+func NewClient(transport io.ReadWriter, timeout time.Duration) *Client {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &Client{
+		transport: transport,
+		reader:    NewReader(transport),
+		timeout:   timeout,
+	}
+}
+
+// Timeout returns the time the Client waits for a response
+func (c *Client) Timeout() time.Duration {
+	return c.timeout
+}
+
+// SetTimeout changes the time the Client waits for each response, e.g. for a device on a shared
+// transport that takes longer to respond than the others. Timeouts of 0 or less are ignored.
+func (c *Client) SetTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		c.timeout = timeout
+	}
+}
+
+// Transceive sends req and waits for the device's response. The response must echo the address and
+// command of req, or ErrAddressMismatch or ErrCommandMismatch is returned.
+// A response with a non-zero State is returned without an error, its meaning depends on the device.
+func (c *Client) Transceive(ctx context.Context, req Request) (Response, error) {
+	if err := ctx.Err(); err != nil {
+		return Response{}, err
+	}
+
+	err := c.Send(req)
+	if err != nil {
+		return Response{}, err
+	}
+
+	res, err := c.Receive(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+
+	// a frame left over from an earlier command must not be taken as the response to this one
+	if res.Addr != req.Addr {
+		return Response{}, fmt.Errorf("%w: sent 0x%02x, received 0x%02x", ErrAddressMismatch, req.Addr, res.Addr)
+	}
+	if res.Cmd != req.Cmd {
+		return Response{}, fmt.Errorf("%w: sent 0x%02x, received 0x%02x", ErrCommandMismatch, req.Cmd, res.Cmd)
+	}
+
+	return res, nil
+}
+
+// Send writes req to the transport without waiting for a response
+func (c *Client) Send(req Request) error {
+	frame, err := req.Encode()
+	if err != nil {
+		return err
+	}
+
+	_, err = c.transport.Write(frame)
+	if err != nil {
+		return fmt.Errorf("unable send data to sensor: %w", err)
+	}
+
+	return nil
+}
+
+// Receive waits for the next response frame until the Client's timeout passes or ctx is done.
+// When ctx is done, received input is discarded so a late response can't be mistaken for the next one.
+func (c *Client) Receive(ctx context.Context) (Response, error) {
+	frame, err := c.readFrame(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+
+	return DecodeResponse(frame)
+}
+
+// ResetInput discards the partial frame and any input that has not been read yet
+func (c *Client) ResetInput() error {
+	c.reader.Reset()
+
+	if transport, ok := c.transport.(InputBufferResetter); ok {
+		return transport.ResetInputBuffer()
+	}
+	return nil
+}
+
+// readFrame reads frames until one is complete, polling in short intervals to notice a done ctx
+func (c *Client) readFrame(ctx context.Context) ([]byte, error) {
+	deadline := time.Now().Add(c.timeout)
+	ctx_deadline, has_ctx_deadline := ctx.Deadline()
+	if has_ctx_deadline && ctx_deadline.Before(deadline) {
+		deadline = ctx_deadline
+	}
+
+	// a response can't arrive before anything was read
+	incomplete := ErrMissingStart
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, c.abortRead(err)
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			if has_ctx_deadline && !ctx_deadline.After(deadline) {
+				return nil, c.abortRead(context.DeadlineExceeded)
+			}
+			return nil, incomplete
+		}
+
+		err := c.setReadTimeout(min(remaining, PollInterval))
+		if err != nil {
+			return nil, fmt.Errorf("failed to set UART read timeout: %w", err)
+		}
+
+		frame, err := c.reader.ReadFrame()
+		switch {
+		case err == nil:
+			return frame, nil
+		case errors.Is(err, io.EOF):
+			if errors.Is(err, ErrMissingStop) {
+				return nil, ErrMissingStop
+			}
+			return nil, ErrMissingStart
+		case errors.Is(err, ErrMissingStart), errors.Is(err, ErrMissingStop):
+			incomplete = err
+		case errors.Is(err, ErrFrameTooLong):
+			return nil, err
+		default:
+			return nil, fmt.Errorf("failed to read data from sensor: %w", err)
+		}
+	}
+}
+
+// abortRead discards any partially received frame after ctx ended the read
+func (c *Client) abortRead(cause error) error {
+	if err := c.ResetInput(); err != nil {
+		return fmt.Errorf("waiting for response from sensor: %w (failed to reset UART input buffer: %v)", cause, err)
+	}
+	return fmt.Errorf("waiting for response from sensor: %w", cause)
+}
+
+// setReadTimeout bounds the next read on the transport, if the transport supports it
+func (c *Client) setReadTimeout(t time.Duration) error {
+	switch transport := c.transport.(type) {
+	case ReadTimeoutSetter:
+		return transport.SetReadTimeout(t)
+	case ReadDeadlineSetter:
+		return transport.SetReadDeadline(time.Now().Add(t))
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package shdlc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/MasandeM/sps30/shdlc"
+	"github.com/MasandeM/sps30/simulator"
+)
+
+func TestTransceive(t *testing.T) {
+	sim := simulator.New(simulator.WithAddress(2), simulator.WithFirmware(2, 3))
+	defer sim.Close()
+
+	client := shdlc.NewClient(sim, 50*time.Millisecond)
+
+	res, err := client.Transceive(context.Background(), shdlc.Request{Addr: 2, Cmd: 0xd1})
+	if err != nil {
+		t.Fatalf("Transceive() failed: %v", err)
+	}
+	if res.Addr != 2 || res.Cmd != 0xd1 || res.State != 0 || !bytes.Equal(res.Data[:2], []byte{2, 3}) {
+		t.Errorf("Transceive() = %+v. Expected version 2.3 from address 2", res)
+	}
+
+	// reading measured values in Idle-mode is not allowed
+	res, err = client.Transceive(context.Background(), shdlc.Request{Addr: 2, Cmd: 0x03})
+	if err != nil {
+		t.Fatalf("Transceive() failed: %v", err)
+	}
+	if res.State != 0x43 {
+		t.Errorf("Transceive() state = 0x%02x. Expected 0x43", res.State)
+	}
+}
+
+func TestTransceiveNoResponse(t *testing.T) {
+	sim := simulator.New(simulator.WithAddress(2))
+	defer sim.Close()
+
+	client := shdlc.NewClient(sim, 20*time.Millisecond)
+
+	_, err := client.Transceive(context.Background(), shdlc.Request{Addr: 1, Cmd: 0xd1})
+	if !errors.Is(err, shdlc.ErrMissingStart) {
+		t.Errorf("Transceive() to a missing device error = %v. Expected %v", err, shdlc.ErrMissingStart)
+	}
+}
+
+func TestTransceiveStaleResponse(t *testing.T) {
+	sim := simulator.New()
+	defer sim.Close()
+
+	client := shdlc.NewClient(sim, 50*time.Millisecond)
+
+	// a response nobody waited for
+	if err := client.Send(shdlc.Request{Cmd: 0xd1}); err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+
+	_, err := client.Transceive(context.Background(), shdlc.Request{Cmd: 0xd2, Data: []byte{0x00}})
+	if !errors.Is(err, shdlc.ErrCommandMismatch) {
+		t.Errorf("Transceive() error = %v. Expected %v", err, shdlc.ErrCommandMismatch)
+	}
+
+	if err := client.ResetInput(); err != nil {
+		t.Fatalf("ResetInput() failed: %v", err)
+	}
+
+	_, err = client.Transceive(context.Background(), shdlc.Request{Cmd: 0xd2, Data: []byte{0x00}})
+	if err != nil {
+		t.Errorf("Transceive() after ResetInput() failed: %v", err)
+	}
+}
+
+func TestTransceiveCanceled(t *testing.T) {
+	sim := simulator.New(simulator.WithAddress(2))
+	defer sim.Close()
+
+	client := shdlc.NewClient(sim, time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.Transceive(ctx, shdlc.Request{Addr: 1, Cmd: 0xd1})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Transceive() error = %v. Expected %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Transceive() returned after %v. Expected it to give up at the context deadline", elapsed)
+	}
+}
+
+// byteTransport delivers the received data one byte per Read and discards what is written
+type byteTransport struct {
+	io.Writer
+	chunkReader
+}
+
+func newByteTransport(data []byte) *byteTransport {
+	b := &byteTransport{Writer: io.Discard}
+	for _, c := range data {
+		b.chunks = append(b.chunks, []byte{c})
+	}
+	return b
+}
+
+func TestSend(t *testing.T) {
+	tests := []struct {
+		request shdlc.Request
+		want    string
+	}{
+		{ // Start measurement
+			request: shdlc.Request{Cmd: 0x00, Data: []byte{0x01, 0x03}},
+			want:    "7e0000020103f97e",
+		},
+		{ // Stop measurement
+			request: shdlc.Request{Cmd: 0x01},
+			want:    "7e000100fe7e",
+		},
+		{ // Read measured values
+			request: shdlc.Request{Cmd: 0x03},
+			want:    "7e000300fc7e",
+		},
+		{ // Read auto cleaning interval, with a CRC that needs stuffing
+			request: shdlc.Request{Cmd: 0x80, Data: []byte{0x00}},
+			want:    "7e008001007d5e7e",
+		},
+		{ // Sleep
+			request: shdlc.Request{Cmd: 0x10},
+			want:    "7e001000ef7e",
+		},
+		{ // Device reset
+			request: shdlc.Request{Cmd: 0xd3},
+			want:    "7e00d3002c7e",
+		},
+	}
+
+	for _, test := range tests {
+		transport := new(bytes.Buffer)
+		client := shdlc.NewClient(transport, 0)
+
+		if err := client.Send(test.request); err != nil {
+			t.Fatalf("Send(%+v) failed: %v", test.request, err)
+		}
+		if got := hex.EncodeToString(transport.Bytes()); got != test.want {
+			t.Errorf("Send(%+v) wrote 0x%v. Expected 0x%v", test.request, got, test.want)
+		}
+	}
+}
+
+func TestReceive(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		want    shdlc.Response
+		wantErr error
+	}{
+		{
+			name:  "stop measurement",
+			input: []byte{0x7e, 0x00, 0x01, 0x00, 0x00, 0xfe, 0x7e},
+			want:  shdlc.Response{Cmd: 0x01, Data: []byte{}},
+		},
+		{
+			name:  "read version, with byte stuffing",
+			input: []byte{0x7e, 0x00, 0xd1, 0x00, 0x07, 0x02, 0x7d, 0x31, 0x00, 0x07, 0x00, 0x02, 0x00, 0x0b, 0x7e},
+			want:  shdlc.Response{Cmd: 0xd1, Data: []byte{0x02, 0x11, 0x00, 0x07, 0x00, 0x02, 0x00}},
+		},
+		{
+			name:  "garbage before start byte",
+			input: []byte{0x00, 0xff, 0x13, 0x7e, 0x00, 0xd2, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x28, 0x7e},
+			want:  shdlc.Response{Cmd: 0xd2, Data: []byte{0x00, 0x00, 0x00, 0x00, 0x00}},
+		},
+		{
+			name:  "stop byte of a previous frame before start byte",
+			input: []byte{0x7e, 0x7e, 0x00, 0x00, 0x00, 0x00, 0xff, 0x7e},
+			want:  shdlc.Response{Cmd: 0x00, Data: []byte{}},
+		},
+		{
+			name:  "stuffed CRC",
+			input: []byte{0x7e, 0x00, 0x80, 0x00, 0x01, 0x00, 0x7d, 0x5e, 0x7e},
+			want:  shdlc.Response{Cmd: 0x80, Data: []byte{0x00}},
+		},
+		{
+			name:    "missing stop byte",
+			input:   []byte{0x7e, 0x00, 0x00, 0x00, 0x00, 0xff},
+			wantErr: shdlc.ErrMissingStop,
+		},
+		{
+			name:    "no start byte",
+			input:   []byte{0x00, 0x00, 0x00},
+			wantErr: shdlc.ErrMissingStart,
+		},
+		{
+			name:    "CRC mismatch",
+			input:   []byte{0x7e, 0x00, 0x00, 0x00, 0x00, 0xfe, 0x7e},
+			wantErr: shdlc.ErrCRCMismatch,
+		},
+		{
+			name:    "frame too short",
+			input:   []byte{0x7e, 0x00, 0x00, 0xff, 0x7e},
+			wantErr: shdlc.ErrInvalidFrame,
+		},
+		{
+			name:    "frame exceeds maximum size",
+			input:   append(append([]byte{0x7e}, make([]byte, 600)...), 0x7e),
+			wantErr: shdlc.ErrFrameTooLong,
+		},
+	}
+
+	for _, test := range tests {
+		client := shdlc.NewClient(newByteTransport(test.input), 20*time.Millisecond)
+
+		got, err := client.Receive(context.Background())
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("%s: Receive() error = %v. Expected %v", test.name, err, test.wantErr)
+			continue
+		}
+		if test.wantErr == nil && (got.Addr != test.want.Addr || got.Cmd != test.want.Cmd || got.State != test.want.State || !bytes.Equal(got.Data, test.want.Data)) {
+			t.Errorf("%s: Receive() = %+v. Expected %+v", test.name, got, test.want)
+		}
+	}
+}
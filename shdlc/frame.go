@@ -0,0 +1,232 @@
+// Package shdlc implements the Sensirion SHDLC protocol spoken over UART by the SPS30 and other Sensirion sensors.
+//
+// A master sends a Request frame (MOSI) to the device at an address, which answers with a Response frame (MISO)
+// echoing the address and command, followed by a state byte that is 0 if the command was executed.
+// Frames start and stop with 0x7E, bytes that would be mistaken for control bytes are escaped, and a checksum
+// protects the frame content.
+package shdlc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Start is the byte that starts and stops every frame
+const Start = 0x7e
+
+// Escape precedes a stuffed byte, which is the original byte XOR 0x20
+const Escape = 0x7d
+
+// MaxDataLen is the most data a single frame can carry
+const MaxDataLen = 255
+
+// MaxFrameLen is the length of the longest encoded frame: a Response with MaxDataLen bytes of data, all stuffed
+const MaxFrameLen = 2 + 2*(5+MaxDataLen)
+
+// Errors returned when a frame is corrupt or incomplete
+var (
+	ErrMissingStart = errors.New("missing SHDLC Start byte in Rx frame")
+	ErrMissingStop  = errors.New("missing SHDLC STOP byte")
+	ErrCRCMismatch  = errors.New("mismatch in CRC")
+	ErrFrameTooLong = errors.New("rx frame exceeds maximum frame size")
+	ErrInvalidFrame = errors.New("malformed rx frame")
+)
+
+// ErrDataTooLong is returned when encoding a frame with more than MaxDataLen bytes of data
+var ErrDataTooLong = errors.New("frame data exceeds 255 bytes")
+
+// Request is a frame sent from the master to a device (MOSI)
+type Request struct {
+	Addr uint8
+	Cmd  uint8
+	Data []byte
+}
+
+// Response is a frame sent from a device to the master (MISO).
+// A non-zero State reports why the device did not execute the command.
+type Response struct {
+	Addr  uint8
+	Cmd   uint8
+	State uint8
+	Data  []byte
+}
+
+// reasons of the states defined by the SHDLC protocol
+var stateReasons = map[uint8]string{
+	0x01: "Wrong data length for this command (too much or little data)",
+	0x02: "Unknown command",
+	0x03: "No access right for command",
+	0x04: "Illegal command parameter or parameter out of allowed range",
+	0x28: "Internal function argument out of range",
+	0x43: "Command not allowed in current state",
+}
+
+// StateError is the non-zero state of a Response, reporting why the device did not execute a command
+type StateError struct {
+	Cmd   uint8
+	State uint8
+}
+
+func (e *StateError) Error() string {
+	reason, ok := stateReasons[e.State]
+	if !ok {
+		reason = fmt.Sprintf("unknown state 0x%02x", e.State)
+	}
+	return fmt.Sprintf("device did not execute command 0x%02x: %v", e.Cmd, reason)
+}
+
+// Err returns a *StateError if the device did not execute the command, or nil
+func (r Response) Err() error {
+	if r.State != 0 {
+		return &StateError{Cmd: r.Cmd, State: r.State}
+	}
+	return nil
+}
+
+// Encode returns the complete frame for r, including the start and stop byte
+func (r Request) Encode() ([]byte, error) {
+	if len(r.Data) > MaxDataLen {
+		return nil, fmt.Errorf("%w: %d bytes", ErrDataTooLong, len(r.Data))
+	}
+
+	return encode([]byte{r.Addr, r.Cmd, uint8(len(r.Data))}, r.Data), nil
+}
+
+// Encode returns the complete frame for r, including the start and stop byte
+func (r Response) Encode() ([]byte, error) {
+	if len(r.Data) > MaxDataLen {
+		return nil, fmt.Errorf("%w: %d bytes", ErrDataTooLong, len(r.Data))
+	}
+
+	return encode([]byte{r.Addr, r.Cmd, r.State, uint8(len(r.Data))}, r.Data), nil
+}
+
+// DecodeRequest decodes a complete MOSI frame, including the start and stop byte
+func DecodeRequest(frame []byte) (Request, error) {
+	content, err := decode(frame, 3)
+	if err != nil {
+		return Request{}, err
+	}
+
+	return Request{
+		Addr: content[0],
+		Cmd:  content[1],
+		Data: content[3 : len(content)-1],
+	}, nil
+}
+
+// DecodeResponse decodes a complete MISO frame, including the start and stop byte
+func DecodeResponse(frame []byte) (Response, error) {
+	content, err := decode(frame, 4)
+	if err != nil {
+		return Response{}, err
+	}
+
+	return Response{
+		Addr:  content[0],
+		Cmd:   content[1],
+		State: content[2],
+		Data:  content[4 : len(content)-1],
+	}, nil
+}
+
+// encode stuffs a frame made of header, data and their checksum
+func encode(header []byte, data []byte) []byte {
+	content := make([]byte, 0, len(header)+len(data)+1)
+	content = append(content, header...)
+	content = append(content, data...)
+	content = append(content, CRC(content))
+
+	frame := make([]byte, 0, 2+2*len(content))
+	frame = append(frame, Start)
+	frame = append(frame, Stuff(content)...)
+	return append(frame, Start)
+}
+
+// decode unstuffs a frame and verifies it against the data length byte, the last of its headerLen
+// header bytes, and its checksum, returning the frame content without the start and stop byte
+func decode(frame []byte, headerLen int) ([]byte, error) {
+	if len(frame) == 0 || frame[0] != Start {
+		return nil, ErrMissingStart
+	}
+	if len(frame) < 2 || frame[len(frame)-1] != Start {
+		return nil, ErrMissingStop
+	}
+
+	content, err := Unstuff(frame[1 : len(frame)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	// the header and checksum are always present
+	if len(content) < headerLen+1 {
+		return nil, fmt.Errorf("%w: frame too short", ErrInvalidFrame)
+	}
+
+	if len(content) != headerLen+int(content[headerLen-1])+1 {
+		return nil, fmt.Errorf("%w: frame length does not match its data length", ErrInvalidFrame)
+	}
+
+	if CRC(content[:len(content)-1]) != content[len(content)-1] {
+		return nil, ErrCRCMismatch
+	}
+
+	return content, nil
+}
+
+// CRC calculates the SHDLC checksum of the frame content preceding it: the inverted low byte of the sum of all bytes
+func CRC(data []byte) uint8 {
+	var sum uint8
+
+	for _, b := range data {
+		sum += b
+	}
+
+	return ^sum
+}
+
+// needsStuffing reports whether b must be escaped inside a frame
+func needsStuffing(b byte) bool {
+	return b == Start || b == Escape || b == 0x11 || b == 0x13
+}
+
+// Stuff escapes the bytes of data that are reserved for framing (0x7E, 0x7D) and flow control (0x11, 0x13)
+func Stuff(data []byte) []byte {
+	stuffed := make([]byte, 0, len(data))
+
+	for _, b := range data {
+		if needsStuffing(b) {
+			stuffed = append(stuffed, Escape, b^0x20)
+			continue
+		}
+		stuffed = append(stuffed, b)
+	}
+
+	return stuffed
+}
+
+// Unstuff reverses Stuff. ErrInvalidFrame is returned if data ends with an incomplete escape sequence.
+func Unstuff(data []byte) ([]byte, error) {
+	unstuffed := make([]byte, 0, len(data))
+
+	for i := 0; i < len(data); i++ {
+		if data[i] != Escape {
+			unstuffed = append(unstuffed, data[i])
+			continue
+		}
+
+		i++
+		if i == len(data) {
+			return nil, fmt.Errorf("%w: frame ends with an incomplete escape sequence", ErrInvalidFrame)
+		}
+
+		// bytes that are escaped without needing it are taken as they are
+		if needsStuffing(data[i] ^ 0x20) {
+			unstuffed = append(unstuffed, data[i]^0x20)
+		} else {
+			unstuffed = append(unstuffed, data[i])
+		}
+	}
+
+	return unstuffed, nil
+}
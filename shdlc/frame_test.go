@@ -0,0 +1,228 @@
+package shdlc_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/MasandeM/sps30/shdlc"
+)
+
+func TestCRC(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want uint8
+	}{
+		{ // Stop Measurement
+			data: []byte{0x00, 0x01, 0x00},
+			want: 0xFE,
+		},
+		{ // Start Measurement
+			data: []byte{0x00, 0x00, 0x02, 0x01, 0x03},
+			want: 0xF9,
+		},
+		{ // Start Fan Cleaning
+			data: []byte{0x00, 0x56, 0x00},
+			want: 0xA9,
+		},
+		{ // non existing cmd
+			data: []byte{0xFF, 0xFF, 0x01, 0xFF},
+			want: 0x1,
+		},
+		{ // non existing cmd
+			data: []byte{0x20, 0xF0, 0x04, 0x50, 0x50, 0x50, 0x50},
+			want: 0xab,
+		},
+	}
+
+	for _, test := range tests {
+		if got := shdlc.CRC(test.data); got != test.want {
+			t.Errorf("CRC(0x%x) = 0x%x. Expected 0x%x", test.data, got, test.want)
+		}
+	}
+}
+
+func TestStuff(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want []byte
+	}{
+		{
+			data: []byte{0x00},
+			want: []byte{0x00},
+		},
+		{
+			data: []byte{0x7E},
+			want: []byte{0x7D, 0x5E},
+		},
+		{
+			data: []byte{0x7E, 0x7D, 0x11, 0x13},
+			want: []byte{0x7D, 0x5E, 0x7D, 0x5D, 0x7D, 0x31, 0x7D, 0x33},
+		},
+		{
+			data: []byte{0x34, 0x03, 0x00, 0xF1},
+			want: []byte{0x34, 0x03, 0x00, 0xF1},
+		},
+	}
+
+	for _, test := range tests {
+		got := shdlc.Stuff(test.data)
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("Stuff(0x%x) = 0x%x. Expected 0x%x", test.data, got, test.want)
+		}
+
+		unstuffed, err := shdlc.Unstuff(got)
+		if err != nil || !bytes.Equal(unstuffed, test.data) {
+			t.Errorf("Unstuff(0x%x) = 0x%x, %v. Expected 0x%x", got, unstuffed, err, test.data)
+		}
+	}
+}
+
+func TestUnstuff(t *testing.T) {
+	tests := []struct {
+		data    []byte
+		want    []byte
+		wantErr error
+	}{
+		{
+			data: []byte{0x7d, 0x31, 0x03, 0x05},
+			want: []byte{0x11, 0x03, 0x05},
+		},
+		{
+			data: []byte{0x7d, 0x31, 0x7D, 0x33},
+			want: []byte{0x11, 0x13},
+		},
+		{
+			data: []byte{0xFF, 0x31, 0x03, 0x05},
+			want: []byte{0xFF, 0x31, 0x03, 0x05},
+		},
+		{ // needlessly escaped byte
+			data: []byte{0x7d, 0x03},
+			want: []byte{0x03},
+		},
+		{
+			data:    []byte{0x03, 0x7d},
+			wantErr: shdlc.ErrInvalidFrame,
+		},
+	}
+
+	for _, test := range tests {
+		got, err := shdlc.Unstuff(test.data)
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("Unstuff(0x%x) error = %v. Expected %v", test.data, err, test.wantErr)
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("Unstuff(0x%x) = 0x%x. Expected 0x%x", test.data, got, test.want)
+		}
+	}
+}
+
+func TestRequestEncode(t *testing.T) {
+	tests := []struct {
+		request shdlc.Request
+		want    []byte
+	}{
+		{ // Start measurement
+			request: shdlc.Request{Addr: 0x00, Cmd: 0x00, Data: []byte{0x01, 0x03}},
+			want:    []byte{0x7e, 0x00, 0x00, 0x02, 0x01, 0x03, 0xf9, 0x7e},
+		},
+		{ // Read auto cleaning interval, with a CRC that needs stuffing
+			request: shdlc.Request{Addr: 0x00, Cmd: 0x80, Data: []byte{0x00}},
+			want:    []byte{0x7e, 0x00, 0x80, 0x01, 0x00, 0x7d, 0x5e, 0x7e},
+		},
+		{ // Wake-up, with a stuffed command
+			request: shdlc.Request{Addr: 0x00, Cmd: 0x11},
+			want:    []byte{0x7e, 0x00, 0x7d, 0x31, 0x00, 0xee, 0x7e},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := test.request.Encode()
+		if err != nil {
+			t.Fatalf("Encode(%+v) failed: %v", test.request, err)
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("Encode(%+v) = 0x%x. Expected 0x%x", test.request, got, test.want)
+		}
+
+		decoded, err := shdlc.DecodeRequest(got)
+		if err != nil || decoded.Addr != test.request.Addr || decoded.Cmd != test.request.Cmd || !bytes.Equal(decoded.Data, test.request.Data) {
+			t.Errorf("DecodeRequest(0x%x) = %+v, %v. Expected %+v", got, decoded, err, test.request)
+		}
+	}
+
+	_, err := shdlc.Request{Data: make([]byte, 256)}.Encode()
+	if !errors.Is(err, shdlc.ErrDataTooLong) {
+		t.Errorf("Encode() with 256 bytes of data error = %v. Expected %v", err, shdlc.ErrDataTooLong)
+	}
+}
+
+func TestDecodeResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   []byte
+		want    shdlc.Response
+		wantErr error
+	}{
+		{
+			name:  "read version, with byte stuffing",
+			frame: []byte{0x7e, 0x00, 0xd1, 0x00, 0x07, 0x02, 0x7D, 0x31, 0x00, 0x07, 0x00, 0x02, 0x00, 0x0b, 0x7e},
+			want:  shdlc.Response{Addr: 0x00, Cmd: 0xd1, Data: []byte{0x02, 0x11, 0x00, 0x07, 0x00, 0x02, 0x00}},
+		},
+		{
+			name:  "command not allowed",
+			frame: []byte{0x7e, 0x00, 0x03, 0x43, 0x00, 0xb9, 0x7e},
+			want:  shdlc.Response{Addr: 0x00, Cmd: 0x03, State: 0x43, Data: []byte{}},
+		},
+		{
+			name:    "CRC mismatch",
+			frame:   []byte{0x7e, 0x00, 0x00, 0x00, 0x00, 0xfe, 0x7e},
+			wantErr: shdlc.ErrCRCMismatch,
+		},
+		{
+			name:    "frame too short",
+			frame:   []byte{0x7e, 0x00, 0x00, 0xff, 0x7e},
+			wantErr: shdlc.ErrInvalidFrame,
+		},
+		{
+			name:    "data length mismatch",
+			frame:   []byte{0x7e, 0x00, 0x00, 0x00, 0x02, 0x00, 0xfd, 0x7e},
+			wantErr: shdlc.ErrInvalidFrame,
+		},
+		{
+			name:    "missing stop byte",
+			frame:   []byte{0x7e, 0x00, 0x00, 0x00, 0x00, 0xff},
+			wantErr: shdlc.ErrMissingStop,
+		},
+	}
+
+	for _, test := range tests {
+		got, err := shdlc.DecodeResponse(test.frame)
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("%s: DecodeResponse() error = %v. Expected %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got.Addr != test.want.Addr || got.Cmd != test.want.Cmd || got.State != test.want.State || !bytes.Equal(got.Data, test.want.Data) {
+			t.Errorf("%s: DecodeResponse() = %+v. Expected %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestResponseErr(t *testing.T) {
+	if err := (shdlc.Response{Cmd: 0x03}).Err(); err != nil {
+		t.Errorf("Err() of a response with state 0 = %v. Expected nil", err)
+	}
+
+	err := shdlc.Response{Cmd: 0x03, State: 0x43}.Err()
+
+	var state_err *shdlc.StateError
+	if !errors.As(err, &state_err) || state_err.Cmd != 0x03 || state_err.State != 0x43 {
+		t.Fatalf("Err() = %v. Expected a StateError for command 0x03 with state 0x43", err)
+	}
+	if want := "device did not execute command 0x03: Command not allowed in current state"; err.Error() != want {
+		t.Errorf("Error() = %q. Expected %q", err.Error(), want)
+	}
+}
@@ -0,0 +1,120 @@
+package shdlc
+
+import (
+	"errors"
+	"io"
+)
+
+// Reader splits a byte stream into frames. Bytes received outside of a frame are discarded,
+// and bytes following a frame are kept for the next one.
+type Reader struct {
+	r       io.Reader
+	buf     []byte
+	pending []byte
+	frame   []byte
+	started bool
+}
+
+// NewReader creates a Reader reading frames from r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r:     r,
+		buf:   make([]byte, MaxFrameLen),
+		frame: make([]byte, 0, MaxFrameLen),
+	}
+}
+
+// ReadFrame reads until a complete frame has been received and returns it, including the start and stop byte.
+//
+// When a read returns no data, e.g. because the read timeout of a serial port passed, ReadFrame returns
+// ErrMissingStart, or ErrMissingStop if a frame has begun. The partial frame is kept, and calling ReadFrame
+// again continues it. At the end of the stream these errors also match io.EOF.
+// ErrFrameTooLong is returned when a frame exceeds MaxFrameLen, and the frame is discarded.
+func (r *Reader) ReadFrame() ([]byte, error) {
+	for {
+		frame, err := r.scan()
+		if frame != nil || err != nil {
+			return frame, err
+		}
+
+		n, err := r.r.Read(r.buf)
+		r.pending = r.buf[:n]
+
+		if n > 0 && err == nil {
+			continue
+		}
+
+		frame, scan_err := r.scan()
+		if frame != nil || scan_err != nil {
+			return frame, scan_err
+		}
+
+		switch {
+		case err == io.EOF:
+			return nil, errors.Join(r.missing(), io.EOF)
+		case err != nil && !isTimeout(err):
+			return nil, err
+		}
+
+		return nil, r.missing()
+	}
+}
+
+// Reset discards the partial frame and any bytes received after the last frame
+func (r *Reader) Reset() {
+	r.pending = nil
+	r.frame = r.frame[:0]
+	r.started = false
+}
+
+// scan consumes pending bytes until a frame is complete
+func (r *Reader) scan() ([]byte, error) {
+	for len(r.pending) > 0 {
+		b := r.pending[0]
+		r.pending = r.pending[1:]
+
+		if !r.started {
+			r.started = b == Start
+			continue
+		}
+
+		if b == Start {
+			// a stop byte directly followed by a start byte
+			if len(r.frame) == 0 {
+				continue
+			}
+
+			frame := make([]byte, 0, len(r.frame)+2)
+			frame = append(frame, Start)
+			frame = append(frame, r.frame...)
+			frame = append(frame, Start)
+
+			r.frame = r.frame[:0]
+			r.started = false
+			return frame, nil
+		}
+
+		if len(r.frame) == MaxFrameLen-2 {
+			r.frame = r.frame[:0]
+			r.started = false
+			return nil, ErrFrameTooLong
+		}
+		r.frame = append(r.frame, b)
+	}
+
+	return nil, nil
+}
+
+// missing returns the error for a frame that is not complete yet
+func (r *Reader) missing() error {
+	if r.started {
+		return ErrMissingStop
+	}
+	return ErrMissingStart
+}
+
+// isTimeout reports whether err is a read deadline passing rather than a failure
+func isTimeout(err error) bool {
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
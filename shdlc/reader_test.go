@@ -0,0 +1,118 @@
+package shdlc_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/MasandeM/sps30/shdlc"
+)
+
+// chunkReader returns its chunks one per Read, and no data once they are exhausted
+type chunkReader struct {
+	chunks [][]byte
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(c.chunks) == 0 {
+		return 0, nil
+	}
+	n := copy(p, c.chunks[0])
+	c.chunks = c.chunks[1:]
+	return n, nil
+}
+
+func TestReadFrame(t *testing.T) {
+	stop := []byte{0x7e, 0x00, 0x01, 0x00, 0x00, 0xfe, 0x7e}
+	version := []byte{0x7e, 0x00, 0xd1, 0x00, 0x07, 0x02, 0x7D, 0x31, 0x00, 0x07, 0x00, 0x02, 0x00, 0x0b, 0x7e}
+
+	tests := []struct {
+		name string
+		data []byte
+		want [][]byte
+	}{
+		{
+			name: "garbage before the start byte",
+			data: append([]byte{0x00, 0xff, 0x13}, stop...),
+			want: [][]byte{stop},
+		},
+		{
+			name: "two frames in one read",
+			data: append(append([]byte{}, stop...), version...),
+			want: [][]byte{stop, version},
+		},
+		{
+			name: "stop byte of a previous frame before the start byte",
+			data: append([]byte{0x7e}, stop...),
+			want: [][]byte{stop},
+		},
+	}
+
+	for _, test := range tests {
+		reader := shdlc.NewReader(bytes.NewReader(test.data))
+
+		for i, want := range test.want {
+			got, err := reader.ReadFrame()
+			if err != nil {
+				t.Fatalf("%s: ReadFrame() %d failed: %v", test.name, i, err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s: ReadFrame() %d = 0x%x. Expected 0x%x", test.name, i, got, want)
+			}
+		}
+
+		_, err := reader.ReadFrame()
+		if !errors.Is(err, shdlc.ErrMissingStart) || !errors.Is(err, io.EOF) {
+			t.Errorf("%s: ReadFrame() at the end error = %v. Expected %v and %v", test.name, err, shdlc.ErrMissingStart, io.EOF)
+		}
+	}
+}
+
+func TestReadFramePartial(t *testing.T) {
+	// no more data after the first chunk, like a serial port whose read timeout passed
+	r := &chunkReader{chunks: [][]byte{{0x7e, 0x00, 0x01}}}
+	reader := shdlc.NewReader(r)
+
+	_, err := reader.ReadFrame()
+	if !errors.Is(err, shdlc.ErrMissingStop) {
+		t.Fatalf("ReadFrame() of a partial frame error = %v. Expected %v", err, shdlc.ErrMissingStop)
+	}
+
+	r.chunks = [][]byte{{0x00, 0x00, 0xfe, 0x7e}}
+
+	got, err := reader.ReadFrame()
+	if err != nil {
+		t.Fatalf("ReadFrame() failed: %v", err)
+	}
+	if want := []byte{0x7e, 0x00, 0x01, 0x00, 0x00, 0xfe, 0x7e}; !bytes.Equal(got, want) {
+		t.Errorf("ReadFrame() = 0x%x. Expected 0x%x", got, want)
+	}
+}
+
+func TestReadFrameTooLong(t *testing.T) {
+	data := append([]byte{0x7e}, make([]byte, shdlc.MaxFrameLen)...)
+	reader := shdlc.NewReader(bytes.NewReader(data))
+
+	_, err := reader.ReadFrame()
+	if !errors.Is(err, shdlc.ErrFrameTooLong) {
+		t.Errorf("ReadFrame() error = %v. Expected %v", err, shdlc.ErrFrameTooLong)
+	}
+}
+
+func TestReaderReset(t *testing.T) {
+	r := &chunkReader{chunks: [][]byte{{0x7e, 0x00, 0x01}}}
+	reader := shdlc.NewReader(r)
+
+	_, err := reader.ReadFrame()
+	if !errors.Is(err, shdlc.ErrMissingStop) {
+		t.Fatalf("ReadFrame() of a partial frame error = %v. Expected %v", err, shdlc.ErrMissingStop)
+	}
+
+	reader.Reset()
+
+	_, err = reader.ReadFrame()
+	if !errors.Is(err, shdlc.ErrMissingStart) {
+		t.Errorf("ReadFrame() after Reset() error = %v. Expected %v", err, shdlc.ErrMissingStart)
+	}
+}
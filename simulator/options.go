@@ -0,0 +1,118 @@
+package simulator
+
+import (
+	"sync"
+	"time"
+)
+
+// Option configures a Simulator created by New
+type Option func(*config)
+
+type config struct {
+	model        Model
+	addr         uint8
+	productType  string
+	serialNumber string
+	firmware     [2]uint8
+	hardware     uint8
+	interval     time.Duration
+	series       Series
+	now          func() time.Time
+}
+
+func newConfig(opts []Option) config {
+	cfg := config{
+		addr:         0,
+		serialNumber: "F1A2B3C4D5E6F708",
+		firmware:     [2]uint8{2, 3},
+		hardware:     7,
+		interval:     time.Second,
+		series:       Wave(12, 6, 10*time.Minute, 1.5, 1),
+		now:          time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.productType == "" {
+		cfg.productType = cfg.model.productType()
+	}
+
+	return cfg
+}
+
+// WithModel sets the sensor to simulate, SPS30 by default
+func WithModel(model Model) Option {
+	return func(c *config) {
+		c.model = model
+	}
+}
+
+// WithAddress sets the SHDLC address the simulator answers to, 0 by default like the SPS30
+func WithAddress(addr uint8) Option {
+	return func(c *config) {
+		c.addr = addr
+	}
+}
+
+// WithDeviceInfo sets the product type and serial number.
+// By default the product type of the model, e.g. "00080000" for the SPS30, and a fixed serial number are reported.
+func WithDeviceInfo(productType, serialNumber string) Option {
+	return func(c *config) {
+		c.productType = productType
+		c.serialNumber = serialNumber
+	}
+}
+
+// WithFirmware sets the reported firmware version, 2.3 by default
+func WithFirmware(major, minor uint8) Option {
+	return func(c *config) {
+		c.firmware = [2]uint8{major, minor}
+	}
+}
+
+// WithMeasurementInterval sets how often new measured values become available, every second by default
+func WithMeasurementInterval(interval time.Duration) Option {
+	return func(c *config) {
+		if interval > 0 {
+			c.interval = interval
+		}
+	}
+}
+
+// WithSeries sets the values the simulator measures.
+// By default PM2.5 follows a slow Wave around 12µg/m³ with some noise, in ordinary indoor conditions.
+func WithSeries(series Series) Option {
+	return func(c *config) {
+		c.series = series
+	}
+}
+
+// WithClock replaces time.Now as the simulator's clock, e.g. by the Now method of a ManualClock, so tests can
+// control when new values are measured
+func WithClock(now func() time.Time) Option {
+	return func(c *config) {
+		c.now = now
+	}
+}
+
+// ManualClock is a clock for WithClock that only moves when advanced. The zero value starts at the zero time.
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// Now returns the current time of the clock
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
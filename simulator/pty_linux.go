@@ -0,0 +1,107 @@
+//go:build linux
+
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// PTY is a Linux pseudo-terminal with a Simulator attached to its master side.
+// Its slave side, at Path, behaves like the serial port of an SPS30 connected over USB.
+type PTY struct {
+	master *os.File
+	slave  *os.File
+	path   string
+	done   chan error
+}
+
+// ListenPTY creates a pseudo-terminal and serves the simulator on it until the PTY is closed
+func (s *Simulator) ListenPTY() (*PTY, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not open pseudo-terminal: %w", err)
+	}
+
+	unlock := int32(0)
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock)))
+	if errno != 0 {
+		master.Close()
+		return nil, fmt.Errorf("could not unlock pseudo-terminal: %w", errno)
+	}
+
+	var number uint32
+	_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&number)))
+	if errno != 0 {
+		master.Close()
+		return nil, fmt.Errorf("could not get pseudo-terminal number: %w", errno)
+	}
+
+	path := fmt.Sprintf("/dev/pts/%d", number)
+
+	// holding the slave side open keeps the master readable while no client is connected
+	slave, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("could not open pseudo-terminal %s: %w", path, err)
+	}
+
+	err = makeRaw(slave)
+	if err != nil {
+		slave.Close()
+		master.Close()
+		return nil, err
+	}
+
+	pty := &PTY{master: master, slave: slave, path: path, done: make(chan error, 1)}
+
+	go func() {
+		pty.done <- s.Serve(master)
+	}()
+
+	return pty, nil
+}
+
+// makeRaw switches off echo and line editing on a terminal, so bytes pass through unchanged like on a serial port
+func makeRaw(tty *os.File) error {
+	var termios syscall.Termios
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	if errno != 0 {
+		return fmt.Errorf("could not read pseudo-terminal attributes: %w", errno)
+	}
+
+	termios.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	termios.Oflag &^= syscall.OPOST
+	termios.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	termios.Cflag &^= syscall.CSIZE | syscall.PARENB
+	termios.Cflag |= syscall.CS8
+
+	_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&termios)))
+	if errno != 0 {
+		return fmt.Errorf("could not set pseudo-terminal attributes: %w", errno)
+	}
+
+	return nil
+}
+
+// Path returns the path of the slave side to open as a serial port, such as /dev/pts/3
+func (p *PTY) Path() string {
+	return p.path
+}
+
+// Close removes the pseudo-terminal and waits for the simulator to stop serving it
+func (p *PTY) Close() error {
+	err := errors.Join(p.master.Close(), p.slave.Close())
+
+	// reading the master fails with EIO or ErrClosed once both sides are closed
+	serve_err := <-p.done
+	if serve_err != nil && !errors.Is(serve_err, os.ErrClosed) && !errors.Is(serve_err, syscall.EIO) {
+		err = errors.Join(err, serve_err)
+	}
+
+	return err
+}
@@ -0,0 +1,40 @@
+//go:build linux
+
+package simulator_test
+
+import (
+	"testing"
+
+	"github.com/MasandeM/sps30"
+	"github.com/MasandeM/sps30/simulator"
+)
+
+func TestListenPTY(t *testing.T) {
+	sim := simulator.New(simulator.WithDeviceInfo("00080000", "0123456789ABCDEF"))
+
+	pty, err := sim.ListenPTY()
+	if err != nil {
+		t.Skipf("pseudo-terminals unavailable: %v", err)
+	}
+
+	device, err := sps30.Open(pty.Path(), sps30.WithProbe())
+	if err != nil {
+		pty.Close()
+		t.Fatalf("Open(%s) failed: %v", pty.Path(), err)
+	}
+
+	info, err := device.ReadDeviceInfo()
+	if err != nil {
+		t.Errorf("ReadDeviceInfo() failed: %v", err)
+	}
+	if info.SerialNumber != "0123456789ABCDEF" {
+		t.Errorf("ReadDeviceInfo() = %+v. Expected serial number 0123456789ABCDEF", info)
+	}
+
+	if err := device.Close(); err != nil {
+		t.Errorf("Close() failed: %v", err)
+	}
+	if err := pty.Close(); err != nil {
+		t.Errorf("PTY.Close() failed: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package simulator
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// Model is the sensor a Simulator emulates
+type Model int
+
+const (
+	ModelSPS30 Model = iota
+	// ModelSEN54 measures PM, humidity, temperature and the VOC index
+	ModelSEN54
+	// ModelSEN55 additionally measures the NOx index
+	ModelSEN55
+)
+
+func (m Model) String() string {
+	switch m {
+	case ModelSPS30:
+		return "SPS30"
+	case ModelSEN54:
+		return "SEN54"
+	case ModelSEN55:
+		return "SEN55"
+	}
+	return "Unknown"
+}
+
+// productType returns the product type, or for the SEN5x the product name, reported by the model
+func (m Model) productType() string {
+	if m == ModelSPS30 {
+		return "00080000"
+	}
+	return m.String()
+}
+
+const cmdSEN5xReadDataReady = 0x02
+
+const sen5xSubcmdMeasuredValues = 0x04
+const sen5xDeviceInfoProductName = 0x01
+
+// values the SEN5x reports for signals it can't measure (yet)
+const sen5xUnknownUint16 = 0xffff
+const sen5xUnknownInt16 = 0x7fff
+
+// executeSEN5x runs a command on a simulated SEN5x, returning the state and data of the response
+func (s *Simulator) executeSEN5x(cmd uint8, args []byte) (uint8, []byte) {
+	switch cmd {
+	case cmdStartMeasurement:
+		if len(args) != 1 {
+			return stateWrongDataLength, nil
+		}
+		if args[0] != 0x01 {
+			return stateIllegalParameter, nil
+		}
+		if s.mode != ModeIdle {
+			return stateCommandNotAllowed, nil
+		}
+		s.mode = ModeMeasurement
+		s.started = s.cfg.now()
+		s.lastRead = 0
+		return stateOK, nil
+
+	case cmdStopMeasurement:
+		if len(args) != 0 {
+			return stateWrongDataLength, nil
+		}
+		if s.mode != ModeMeasurement {
+			return stateCommandNotAllowed, nil
+		}
+		s.mode = ModeIdle
+		return stateOK, nil
+
+	case cmdSEN5xReadDataReady:
+		if len(args) != 1 {
+			return stateWrongDataLength, nil
+		}
+		if args[0] != 0x00 {
+			return stateIllegalParameter, nil
+		}
+		ready := uint8(0)
+		if s.mode == ModeMeasurement && s.measured() > s.lastRead {
+			ready = 1
+		}
+		return stateOK, []byte{0x00, ready}
+
+	case cmdReadMeasurement:
+		if len(args) != 1 {
+			return stateWrongDataLength, nil
+		}
+		if args[0] != sen5xSubcmdMeasuredValues {
+			return stateIllegalParameter, nil
+		}
+		if s.mode != ModeMeasurement {
+			return stateCommandNotAllowed, nil
+		}
+		return stateOK, s.readSEN5xMeasurement()
+
+	case cmdStartFanCleaning:
+		if len(args) != 0 {
+			return stateWrongDataLength, nil
+		}
+		if s.mode != ModeMeasurement {
+			return stateCommandNotAllowed, nil
+		}
+		return stateOK, nil
+
+	case cmdDeviceInfo:
+		if len(args) != 1 {
+			return stateWrongDataLength, nil
+		}
+		switch args[0] {
+		case sen5xDeviceInfoProductName:
+			return stateOK, append([]byte(s.cfg.productType), 0)
+		case 0x03:
+			return stateOK, append([]byte(s.cfg.serialNumber), 0)
+		}
+		return stateIllegalParameter, nil
+
+	case cmdReadVersion, cmdReadStatusRegister, cmdReset:
+		return s.execute(cmd, args)
+	}
+
+	return stateUnknownCommand, nil
+}
+
+// readSEN5xMeasurement encodes the latest measured values, which are unknown until the first interval passed
+func (s *Simulator) readSEN5xMeasurement() []byte {
+	measured := s.measured()
+	s.lastRead = measured
+
+	data := make([]byte, 0, 16)
+
+	if measured == 0 {
+		for i := 0; i < 4; i++ {
+			data = binary.BigEndian.AppendUint16(data, sen5xUnknownUint16)
+		}
+		for i := 0; i < 4; i++ {
+			data = binary.BigEndian.AppendUint16(data, sen5xUnknownInt16)
+		}
+		return data
+	}
+
+	v := s.cfg.series(time.Duration(measured) * s.cfg.interval)
+
+	for _, pm := range []float32{v.Mc1p0, v.Mc2p5, v.Mc4p0, v.Mc10p0} {
+		data = binary.BigEndian.AppendUint16(data, scaleUint16(pm, 10))
+	}
+
+	data = binary.BigEndian.AppendUint16(data, uint16(scaleInt16(v.Humidity, 100)))
+	data = binary.BigEndian.AppendUint16(data, uint16(scaleInt16(v.Temperature, 200)))
+	data = binary.BigEndian.AppendUint16(data, uint16(scaleInt16(v.VOCIndex, 10)))
+
+	if s.cfg.model == ModelSEN55 {
+		data = binary.BigEndian.AppendUint16(data, uint16(scaleInt16(v.NOxIndex, 10)))
+	} else {
+		data = binary.BigEndian.AppendUint16(data, sen5xUnknownInt16)
+	}
+
+	return data
+}
+
+// scaleUint16 scales value to the integer the SEN5x reports, keeping it below the unknown value
+func scaleUint16(value float32, factor float64) uint16 {
+	return uint16(min(max(math.Round(float64(value)*factor), 0), sen5xUnknownUint16-1))
+}
+
+// scaleInt16 scales value to the integer the SEN5x reports, keeping it below the unknown value
+func scaleInt16(value float32, factor float64) int16 {
+	return int16(min(max(math.Round(float64(value)*factor), math.MinInt16), sen5xUnknownInt16-1))
+}
@@ -0,0 +1,83 @@
+package simulator
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Values are the measured values reported by the simulated sensor, in the units of the datasheets:
+// mass concentrations in µg/m³, number concentrations in #/cm³, the typical particle size in µm,
+// relative humidity in %RH and temperature in °C. The SPS30 doesn't report humidity, temperature
+// and the VOC and NOx indices, and the SEN5x doesn't report number concentrations and particle size.
+type Values struct {
+	Mc1p0               float32
+	Mc2p5               float32
+	Mc4p0               float32
+	Mc10p0              float32
+	Nc0p5               float32
+	Nc1p0               float32
+	Nc2p5               float32
+	Nc4p0               float32
+	Nc10p0              float32
+	TypicalParticleSize float32
+	Humidity            float32
+	Temperature         float32
+	VOCIndex            float32
+	NOxIndex            float32
+}
+
+// Series produces the values measured after the simulator spent elapsed time in Measurement-mode.
+// A Series is called with the simulator locked and must not call back into it.
+type Series func(elapsed time.Duration) Values
+
+// Constant is a Series that always reports v
+func Constant(v Values) Series {
+	return func(time.Duration) Values {
+		return v
+	}
+}
+
+// Derive estimates the values of an urban aerosol with a PM2.5 mass concentration of pm2p5,
+// keeping mass and number concentrations cumulative like the SPS30 reports them, in a room at 22.5°C
+// and 45%RH with average VOC and NOx levels.
+func Derive(pm2p5 float32) Values {
+	pm2p5 = max(pm2p5, 0)
+
+	return Values{
+		Mc1p0:               pm2p5 * 0.94,
+		Mc2p5:               pm2p5,
+		Mc4p0:               pm2p5 * 1.02,
+		Mc10p0:              pm2p5 * 1.03,
+		Nc0p5:               pm2p5 * 6.6,
+		Nc1p0:               pm2p5 * 7.8,
+		Nc2p5:               pm2p5 * 7.9,
+		Nc4p0:               pm2p5 * 7.92,
+		Nc10p0:              pm2p5 * 7.93,
+		TypicalParticleSize: 0.45 + min(pm2p5, 200)/1000,
+		Humidity:            45,
+		Temperature:         22.5,
+		VOCIndex:            100,
+		NOxIndex:            1,
+	}
+}
+
+// Wave is a Series whose PM2.5 concentration oscillates around base by amplitude once every period,
+// with up to noise µg/m³ of pseudo-random deviation per second. The same seed gives the same series.
+func Wave(base, amplitude float32, period time.Duration, noise float32, seed int64) Series {
+	return func(elapsed time.Duration) Values {
+		pm2p5 := float64(base)
+
+		if period > 0 {
+			pm2p5 += float64(amplitude) * math.Sin(2*math.Pi*float64(elapsed)/float64(period))
+		}
+
+		if noise > 0 {
+			// derived from the second so a read returns the same values however often it is repeated
+			random := rand.New(rand.NewSource(seed ^ int64(elapsed/time.Second)))
+			pm2p5 += float64(noise) * (2*random.Float64() - 1)
+		}
+
+		return Derive(float32(pm2p5))
+	}
+}
@@ -0,0 +1,483 @@
+// Package simulator emulates an SPS30 particulate matter sensor, or a SEN54 or SEN55 environmental sensor node,
+// on the slave side of its SHDLC protocol, so applications can be tested end-to-end without hardware.
+//
+// A Simulator is a transport in itself and can be passed to sps30.New, or it can be served over any
+// byte stream with Serve, such as a Linux pseudo-terminal (see ListenPTY) that sps30.Open connects to
+// like to a real serial port.
+package simulator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/MasandeM/sps30/shdlc"
+)
+
+const cmdStartMeasurement = 0x00
+const cmdStopMeasurement = 0x01
+const cmdReadMeasurement = 0x03
+const cmdSleep = 0x10
+const cmdWakeUp = 0x11
+const cmdStartFanCleaning = 0x56
+const cmdAutoCleaningInterval = 0x80
+const cmdDeviceInfo = 0xd0
+const cmdReadVersion = 0xd1
+const cmdReadStatusRegister = 0xd2
+const cmdReset = 0xd3
+
+const formatFloat = 0x03
+const formatUint16 = 0x05
+
+// state bytes of a MISO frame
+const (
+	stateOK                = 0x00
+	stateWrongDataLength   = 0x01
+	stateUnknownCommand    = 0x02
+	stateIllegalParameter  = 0x04
+	stateCommandNotAllowed = 0x43
+)
+
+// byte that switches on the UART interface of a sleeping SPS30
+const wakeupByte = 0xff
+
+// default auto cleaning interval of the SPS30: one week
+const defaultAutoCleaningInterval = 604800
+
+// NoTimeout makes reads block until a response is available
+const NoTimeout time.Duration = -1
+
+// Mode is the operating mode of the simulated SPS30
+type Mode int
+
+const (
+	ModeIdle Mode = iota
+	ModeMeasurement
+	ModeSleep
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeIdle:
+		return "Idle"
+	case ModeMeasurement:
+		return "Measurement"
+	case ModeSleep:
+		return "Sleep"
+	}
+	return "Unknown"
+}
+
+// Simulator is a simulated SPS30, or SEN5x environmental sensor node if created WithModel.
+// Commands are written to it as SHDLC frames and the responses are read back from it. Frames with
+// a wrong checksum or for another address are dropped without a response, like the real device does.
+//
+// A Simulator is safe for concurrent use, but its responses should be consumed either by reading
+// from it or by Serve, not both.
+type Simulator struct {
+	mu        sync.Mutex
+	cfg       config
+	mode      Mode
+	format    uint8
+	listening bool
+	receiving bool
+	frame     []byte
+	out       bytes.Buffer
+	ready     chan struct{}
+	closed    bool
+	timeout   time.Duration
+	started   time.Time
+	lastRead  int64
+	status    uint32
+	cleaning  uint32
+	rejected  int
+}
+
+// New creates a simulated SPS30 in Idle-mode
+func New(opts ...Option) *Simulator {
+	return &Simulator{
+		cfg:      newConfig(opts),
+		mode:     ModeIdle,
+		format:   formatFloat,
+		ready:    make(chan struct{}),
+		timeout:  NoTimeout,
+		cleaning: defaultAutoCleaningInterval,
+	}
+}
+
+// Mode returns the current operating mode
+func (s *Simulator) Mode() Mode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.mode
+}
+
+// SetStatus sets the raw device status register, e.g. to simulate a fan failure
+func (s *Simulator) SetStatus(raw uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status = raw
+}
+
+// Rejected returns the number of frames dropped for being malformed or failing the checksum
+func (s *Simulator) Rejected() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rejected
+}
+
+// Write feeds bytes sent to the sensor into the simulator. Responses to complete frames become readable immediately.
+func (s *Simulator) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	for _, b := range p {
+		s.receive(b)
+	}
+
+	if s.out.Len() > 0 {
+		close(s.ready)
+		s.ready = make(chan struct{})
+	}
+
+	return len(p), nil
+}
+
+// Read reads responses of the simulated sensor. Without responses it waits for the read timeout,
+// then returns 0 bytes and no error like a serial port does. Read returns io.EOF once the simulator is closed.
+func (s *Simulator) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	timeout := s.timeout
+	s.mu.Unlock()
+
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		s.mu.Lock()
+		if s.out.Len() > 0 {
+			n, _ := s.out.Read(p)
+			s.mu.Unlock()
+			return n, nil
+		}
+		if s.closed {
+			s.mu.Unlock()
+			return 0, io.EOF
+		}
+		ready := s.ready
+		s.mu.Unlock()
+
+		select {
+		case <-ready:
+		case <-expired:
+			return 0, nil
+		}
+	}
+}
+
+// SetReadTimeout bounds how long a Read waits for a response, NoTimeout by default
+func (s *Simulator) SetReadTimeout(t time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.timeout = t
+	return nil
+}
+
+// ResetInputBuffer discards responses that have not been read yet
+func (s *Simulator) ResetInputBuffer() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.out.Reset()
+	return nil
+}
+
+// Close disconnects the simulator, unblocking pending reads
+func (s *Simulator) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.ready)
+	}
+
+	return nil
+}
+
+// Serve answers the frames read from conn by writing the responses back to it, until reading from conn fails.
+// The error of the failed read is returned, or nil at io.EOF.
+func (s *Simulator) Serve(conn io.ReadWriter) error {
+	buffer := make([]byte, 256)
+
+	for {
+		n, err := conn.Read(buffer)
+
+		if n > 0 {
+			_, werr := s.Write(buffer[:n])
+			if werr != nil {
+				return werr
+			}
+
+			s.mu.Lock()
+			response := bytes.Clone(s.out.Bytes())
+			s.out.Reset()
+			s.mu.Unlock()
+
+			if len(response) > 0 {
+				_, werr = conn.Write(response)
+				if werr != nil {
+					return werr
+				}
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// receive processes a single byte sent to the sensor
+func (s *Simulator) receive(b byte) {
+	if s.mode == ModeSleep && !s.listening {
+		// the UART interface is off while sleeping, until it receives a wake-up byte
+		s.listening = b == wakeupByte
+		return
+	}
+
+	if b != shdlc.Start {
+		if !s.receiving {
+			return
+		}
+		if len(s.frame) == shdlc.MaxFrameLen-2 {
+			s.rejected++
+			s.receiving = false
+			s.frame = s.frame[:0]
+			return
+		}
+		s.frame = append(s.frame, b)
+		return
+	}
+
+	// a start byte directly after a start byte, or the stop byte of a frame
+	if !s.receiving || len(s.frame) == 0 {
+		s.receiving = true
+		return
+	}
+
+	s.handleFrame(s.frame)
+	s.receiving = false
+	s.frame = s.frame[:0]
+}
+
+// handleFrame executes a complete MOSI frame and queues the response
+func (s *Simulator) handleFrame(stuffed []byte) {
+	frame := make([]byte, 0, len(stuffed)+2)
+	frame = append(frame, shdlc.Start)
+	frame = append(frame, stuffed...)
+	frame = append(frame, shdlc.Start)
+
+	req, err := shdlc.DecodeRequest(frame)
+	if err != nil {
+		s.rejected++
+		return
+	}
+
+	if req.Addr != s.cfg.addr {
+		return
+	}
+
+	var state uint8
+	var data []byte
+	if s.cfg.model == ModelSPS30 {
+		state, data = s.execute(req.Cmd, req.Data)
+	} else {
+		state, data = s.executeSEN5x(req.Cmd, req.Data)
+	}
+
+	// only a device info string configured too long for a frame fails to encode, and goes unanswered
+	response, err := shdlc.Response{Addr: req.Addr, Cmd: req.Cmd, State: state, Data: data}.Encode()
+	if err != nil {
+		return
+	}
+	s.out.Write(response)
+}
+
+// execute runs a command on the simulated sensor, returning the state and data of the response
+func (s *Simulator) execute(cmd uint8, args []byte) (uint8, []byte) {
+	if s.mode == ModeSleep && cmd != cmdWakeUp && cmd != cmdReset {
+		return stateCommandNotAllowed, nil
+	}
+
+	switch cmd {
+	case cmdStartMeasurement:
+		if len(args) != 2 {
+			return stateWrongDataLength, nil
+		}
+		if args[0] != 0x01 || (args[1] != formatFloat && args[1] != formatUint16) {
+			return stateIllegalParameter, nil
+		}
+		if s.mode != ModeIdle {
+			return stateCommandNotAllowed, nil
+		}
+		s.mode = ModeMeasurement
+		s.format = args[1]
+		s.started = s.cfg.now()
+		s.lastRead = 0
+		return stateOK, nil
+
+	case cmdStopMeasurement:
+		if len(args) != 0 {
+			return stateWrongDataLength, nil
+		}
+		if s.mode != ModeMeasurement {
+			return stateCommandNotAllowed, nil
+		}
+		s.mode = ModeIdle
+		return stateOK, nil
+
+	case cmdReadMeasurement:
+		if len(args) != 0 {
+			return stateWrongDataLength, nil
+		}
+		if s.mode != ModeMeasurement {
+			return stateCommandNotAllowed, nil
+		}
+		return stateOK, s.readMeasurement()
+
+	case cmdSleep:
+		if len(args) != 0 {
+			return stateWrongDataLength, nil
+		}
+		if s.mode != ModeIdle {
+			return stateCommandNotAllowed, nil
+		}
+		s.mode = ModeSleep
+		s.listening = false
+		return stateOK, nil
+
+	case cmdWakeUp:
+		if len(args) != 0 {
+			return stateWrongDataLength, nil
+		}
+		if s.mode != ModeSleep {
+			return stateCommandNotAllowed, nil
+		}
+		s.mode = ModeIdle
+		return stateOK, nil
+
+	case cmdStartFanCleaning:
+		if len(args) != 0 {
+			return stateWrongDataLength, nil
+		}
+		if s.mode != ModeMeasurement {
+			return stateCommandNotAllowed, nil
+		}
+		return stateOK, nil
+
+	case cmdAutoCleaningInterval:
+		if len(args) != 1 && len(args) != 5 {
+			return stateWrongDataLength, nil
+		}
+		if args[0] != 0x00 {
+			return stateIllegalParameter, nil
+		}
+		if len(args) == 5 {
+			s.cleaning = binary.BigEndian.Uint32(args[1:])
+			return stateOK, nil
+		}
+		return stateOK, binary.BigEndian.AppendUint32(nil, s.cleaning)
+
+	case cmdDeviceInfo:
+		if len(args) != 1 {
+			return stateWrongDataLength, nil
+		}
+		switch args[0] {
+		case 0x00:
+			return stateOK, append([]byte(s.cfg.productType), 0)
+		case 0x03:
+			return stateOK, append([]byte(s.cfg.serialNumber), 0)
+		}
+		return stateIllegalParameter, nil
+
+	case cmdReadVersion:
+		if len(args) != 0 {
+			return stateWrongDataLength, nil
+		}
+		return stateOK, []byte{s.cfg.firmware[0], s.cfg.firmware[1], 0x00, s.cfg.hardware, 0x00, 0x02, 0x00}
+
+	case cmdReadStatusRegister:
+		if len(args) != 1 {
+			return stateWrongDataLength, nil
+		}
+		data := binary.BigEndian.AppendUint32(nil, s.status)
+		if args[0] == 0x01 {
+			s.status = 0
+		}
+		return stateOK, append(data, 0x00)
+
+	case cmdReset:
+		if len(args) != 0 {
+			return stateWrongDataLength, nil
+		}
+		s.mode = ModeIdle
+		s.format = formatFloat
+		s.listening = false
+		return stateOK, nil
+	}
+
+	return stateUnknownCommand, nil
+}
+
+// measured returns the number of measurement intervals since the measurement was started
+func (s *Simulator) measured() int64 {
+	return int64(s.cfg.now().Sub(s.started) / s.cfg.interval)
+}
+
+// readMeasurement encodes the values measured since the last read, or nothing if there are none yet
+func (s *Simulator) readMeasurement() []byte {
+	measured := s.measured()
+	if measured <= s.lastRead {
+		return nil
+	}
+	s.lastRead = measured
+
+	v := s.cfg.series(time.Duration(measured) * s.cfg.interval)
+	values := []float32{v.Mc1p0, v.Mc2p5, v.Mc4p0, v.Mc10p0, v.Nc0p5, v.Nc1p0, v.Nc2p5, v.Nc4p0, v.Nc10p0, v.TypicalParticleSize}
+
+	if s.format == formatUint16 {
+		// the typical particle size is reported in nm
+		values[9] *= 1000
+
+		data := make([]byte, 0, 2*len(values))
+		for _, value := range values {
+			data = binary.BigEndian.AppendUint16(data, uint16(min(max(math.Round(float64(value)), 0), math.MaxUint16)))
+		}
+		return data
+	}
+
+	data := make([]byte, 0, 4*len(values))
+	for _, value := range values {
+		data = binary.BigEndian.AppendUint32(data, math.Float32bits(value))
+	}
+	return data
+}
@@ -0,0 +1,225 @@
+package simulator_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MasandeM/sps30"
+	"github.com/MasandeM/sps30/simulator"
+)
+
+func newDevice(t *testing.T, opts ...simulator.Option) (sps30.Device, *simulator.Simulator) {
+	sim := simulator.New(opts...)
+	t.Cleanup(func() { sim.Close() })
+
+	return sps30.New(sim, sps30.WithResponseTimeout(50*time.Millisecond)), sim
+}
+
+func TestDeviceInfo(t *testing.T) {
+	device, _ := newDevice(t, simulator.WithDeviceInfo("00080000", "ABCDEF0123456789"), simulator.WithFirmware(2, 2))
+
+	version := sps30.VersionInfo{}
+	if err := device.ReadVersion(&version); err != nil {
+		t.Fatalf("ReadVersion() failed: %v", err)
+	}
+	if version.FirmwarMajor != 2 || version.FirmwarMinor != 2 || version.SHDLCMajor != 2 {
+		t.Errorf("ReadVersion() = %+v. Expected firmware 2.2 and SHDLC 2.0", version)
+	}
+
+	info, err := device.ReadDeviceInfo()
+	if err != nil {
+		t.Fatalf("ReadDeviceInfo() failed: %v", err)
+	}
+	want := sps30.DeviceInfo{ProductType: "00080000", SerialNumber: "ABCDEF0123456789"}
+	if info != want {
+		t.Errorf("ReadDeviceInfo() = %+v. Expected %+v", info, want)
+	}
+}
+
+func TestMeasurement(t *testing.T) {
+	clock := new(simulator.ManualClock)
+	values := simulator.Derive(25)
+	device, sim := newDevice(t, simulator.WithClock(clock.Now), simulator.WithSeries(simulator.Constant(values)))
+
+	if err := device.StartMeasurement(); err != nil {
+		t.Fatalf("StartMeasurement() failed: %v", err)
+	}
+	if sim.Mode() != simulator.ModeMeasurement {
+		t.Errorf("Mode() = %v after StartMeasurement(). Expected Measurement", sim.Mode())
+	}
+
+	measurement := sps30.Measurement{}
+	if err := device.ReadMeasurement(&measurement); !errors.Is(err, sps30.ErrNoNewData) {
+		t.Errorf("ReadMeasurement() before the first interval error = %v. Expected %v", err, sps30.ErrNoNewData)
+	}
+
+	clock.Advance(time.Second)
+
+	if err := device.ReadMeasurement(&measurement); err != nil {
+		t.Fatalf("ReadMeasurement() failed: %v", err)
+	}
+	if measurement.Mc2p5 != values.Mc2p5 || measurement.Nc10p0 != values.Nc10p0 || measurement.TypicalParticleSize != values.TypicalParticleSize {
+		t.Errorf("ReadMeasurement() = %+v. Expected %+v", measurement, values)
+	}
+
+	if err := device.ReadMeasurement(&measurement); !errors.Is(err, sps30.ErrNoNewData) {
+		t.Errorf("ReadMeasurement() repeated within the interval error = %v. Expected %v", err, sps30.ErrNoNewData)
+	}
+
+	if err := device.StopMeasurement(); err != nil {
+		t.Fatalf("StopMeasurement() failed: %v", err)
+	}
+	if sim.Mode() != simulator.ModeIdle {
+		t.Errorf("Mode() = %v after StopMeasurement(). Expected Idle", sim.Mode())
+	}
+}
+
+func TestMeasurementUint16(t *testing.T) {
+	clock := new(simulator.ManualClock)
+	device, _ := newDevice(t, simulator.WithClock(clock.Now), simulator.WithSeries(simulator.Constant(simulator.Values{
+		Mc2p5:               12.4,
+		Nc0p5:               80.6,
+		TypicalParticleSize: 0.52,
+	})))
+
+	if err := device.StartMeasurementFormat(sps30.OutputFormatUint16); err != nil {
+		t.Fatalf("StartMeasurementFormat() failed: %v", err)
+	}
+	clock.Advance(time.Second)
+
+	measurement := sps30.Measurement{}
+	if err := device.ReadMeasurement(&measurement); err != nil {
+		t.Fatalf("ReadMeasurement() failed: %v", err)
+	}
+	if measurement.Mc2p5 != 12 || measurement.Nc0p5 != 81 || measurement.TypicalParticleSize != 0.52 {
+		t.Errorf("ReadMeasurement() = %+v. Expected rounded integer values", measurement)
+	}
+}
+
+func TestCommandNotAllowed(t *testing.T) {
+	device, _ := newDevice(t)
+
+	var device_err *sps30.DeviceError
+
+	err := device.ReadMeasurement(&sps30.Measurement{})
+	if !errors.As(err, &device_err) || device_err.State != 67 {
+		t.Errorf("ReadMeasurement() in Idle-mode error = %v. Expected state 67", err)
+	}
+
+	if err := device.StartMeasurement(); err != nil {
+		t.Fatalf("StartMeasurement() failed: %v", err)
+	}
+
+	err = device.Sleep()
+	if !errors.As(err, &device_err) || device_err.State != 67 {
+		t.Errorf("Sleep() in Measurement-mode error = %v. Expected state 67", err)
+	}
+}
+
+func TestSleepWakeup(t *testing.T) {
+	device, sim := newDevice(t)
+
+	if err := device.Sleep(); err != nil {
+		t.Fatalf("Sleep() failed: %v", err)
+	}
+	if sim.Mode() != simulator.ModeSleep {
+		t.Errorf("Mode() = %v after Sleep(). Expected Sleep", sim.Mode())
+	}
+
+	if err := device.ReadVersion(&sps30.VersionInfo{}); !errors.Is(err, sps30.ErrMissingStart) {
+		t.Errorf("ReadVersion() while sleeping error = %v. Expected %v", err, sps30.ErrMissingStart)
+	}
+
+	if err := device.Wakeup(); err != nil {
+		t.Fatalf("Wakeup() failed: %v", err)
+	}
+	if sim.Mode() != simulator.ModeIdle {
+		t.Errorf("Mode() = %v after Wakeup(). Expected Idle", sim.Mode())
+	}
+}
+
+func TestStatusRegister(t *testing.T) {
+	device, sim := newDevice(t)
+	sim.SetStatus(1 << 4)
+
+	status, err := device.ReadStatusRegister(true)
+	if err != nil {
+		t.Fatalf("ReadStatusRegister() failed: %v", err)
+	}
+	if !status.FanFailure {
+		t.Errorf("ReadStatusRegister() = %+v. Expected a fan failure", status)
+	}
+
+	status, err = device.ReadStatusRegister(false)
+	if err != nil {
+		t.Fatalf("ReadStatusRegister() failed: %v", err)
+	}
+	if status.Raw != 0 {
+		t.Errorf("ReadStatusRegister() = %+v after clearing. Expected 0", status)
+	}
+}
+
+func TestAutoCleaningInterval(t *testing.T) {
+	device, _ := newDevice(t)
+
+	if err := device.WriteAutoCleaningInterval(48 * time.Hour); err != nil {
+		t.Fatalf("WriteAutoCleaningInterval() failed: %v", err)
+	}
+
+	interval, err := device.ReadAutoCleaningInterval()
+	if err != nil {
+		t.Fatalf("ReadAutoCleaningInterval() failed: %v", err)
+	}
+	if interval != 48*time.Hour {
+		t.Errorf("ReadAutoCleaningInterval() = %v. Expected 48h", interval)
+	}
+}
+
+func TestRejectsCorruptFrames(t *testing.T) {
+	sim := simulator.New()
+	sim.SetReadTimeout(10 * time.Millisecond)
+
+	// read version with a wrong checksum, then a stuffed byte cut off by the stop byte
+	sim.Write([]byte{0x7e, 0x00, 0xd1, 0x00, 0x2f, 0x7e, 0x7e, 0x00, 0xd1, 0x00, 0x7d, 0x7e})
+
+	n, err := sim.Read(make([]byte, 64))
+	if n != 0 || err != nil {
+		t.Errorf("Read() = %d, %v after corrupt frames. Expected no response", n, err)
+	}
+	if sim.Rejected() != 2 {
+		t.Errorf("Rejected() = %d. Expected 2", sim.Rejected())
+	}
+}
+
+func TestOtherAddress(t *testing.T) {
+	sim := simulator.New(simulator.WithAddress(3))
+	device := sps30.New(sim, sps30.WithResponseTimeout(20*time.Millisecond))
+
+	if err := device.ReadVersion(&sps30.VersionInfo{}); !errors.Is(err, sps30.ErrMissingStart) {
+		t.Errorf("ReadVersion() for address 0 error = %v. Expected %v", err, sps30.ErrMissingStart)
+	}
+
+	device = sps30.New(sim, sps30.WithAddress(3), sps30.WithResponseTimeout(20*time.Millisecond))
+	if err := device.ReadVersion(&sps30.VersionInfo{}); err != nil {
+		t.Errorf("ReadVersion() for address 3 failed: %v", err)
+	}
+}
+
+func TestWave(t *testing.T) {
+	series := simulator.Wave(10, 5, time.Minute, 1, 42)
+
+	if got := series(15 * time.Second).Mc2p5; got < 14 || got > 16 {
+		t.Errorf("Wave at its peak = %v. Expected 15±1", got)
+	}
+	if series(20*time.Second) != series(20*time.Second) {
+		t.Errorf("Wave is not deterministic")
+	}
+
+	for elapsed := time.Duration(0); elapsed < time.Minute; elapsed += time.Second {
+		v := series(elapsed)
+		if v.Mc1p0 > v.Mc2p5 || v.Mc2p5 > v.Mc4p0 || v.Mc4p0 > v.Mc10p0 || v.Nc0p5 > v.Nc10p0 {
+			t.Fatalf("Wave(%v) = %+v. Expected cumulative concentrations", elapsed, v)
+		}
+	}
+}
@@ -2,35 +2,80 @@
 package sps30
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
+	"sync"
+	"time"
 
-	"go.bug.st/serial"
+	"github.com/MasandeM/sps30/shdlc"
 )
 
-const shdlcStart = 0x7e
-const shdlcStop = 0x7e
-const shdlcFrameMaxTxFrameSize = 520 // start/stop + (4 header + 255 data) * 2 for byte stuffing
-const shdlcFrameMaxRxFrameSize = 522 // start/stop + (5 header + 255 data) * 2 because of byte stuffing
+// default time to wait for the device to send a complete response frame
+const shdlcResponseTimeout = shdlc.DefaultTimeout
 
 const peripheralAddr = 0
+const cmdDeviceInfo = 0xd0
 const cmdReadVersion = 0xd1
+const cmdReadStatusRegister = 0xd2
+const cmdReset = 0xd3
 const cmdStartMeasurement = 0x00
+const cmdStopMeasurement = 0x01
 const CmdReadMeasurement = 0x03
+const cmdSleep = 0x10
 const CmdWakeUp = 0x11
-const ErrNotEnoughData = -1
+const cmdStartFanCleaning = 0x56
+const cmdAutoCleaningInterval = 0x80
 
-// Define the error map
-var errorMap = map[int]string{
-	1:  "Wrong data length for this command (too much or little data)",
-	2:  "Unknown command",
-	3:  "No access right for command",
-	4:  "Illegal command parameter or parameter out of allowed range",
-	40: "Internal function argument out of range",
-	67: "Command not allowed in current state",
-}
+const deviceInfoProductType = 0x00
+const deviceInfoSerialNumber = 0x03
+const deviceInfoMaxLen = 32
+
+// time the device needs to boot after a reset before it accepts new commands
+const resetBootTime = 100 * time.Millisecond
+
+const statusFanSpeedWarning = 1 << 21
+const statusLaserFailure = 1 << 5
+const statusFanFailure = 1 << 4
+
+// OutputFormat selects how the device encodes measured values
+type OutputFormat uint8
+
+const (
+	// OutputFormatFloat reports measured values as big-endian IEEE754 floats
+	OutputFormatFloat OutputFormat = 0x03
+	// OutputFormatUint16 reports measured values as big-endian unsigned 16-bit integers (firmware 2.0 and later)
+	OutputFormatUint16 OutputFormat = 0x05
+)
+
+// device state returned for commands that are not allowed in the current mode
+const stateCommandNotAllowed = 67
+
+// Errors returned when a frame received from the device is corrupt or incomplete
+var (
+	ErrMissingStart = shdlc.ErrMissingStart
+	ErrMissingStop  = shdlc.ErrMissingStop
+	ErrCRCMismatch  = shdlc.ErrCRCMismatch
+	ErrFrameTooLong = shdlc.ErrFrameTooLong
+	ErrInvalidFrame = shdlc.ErrInvalidFrame
+)
+
+// Errors returned when a well-formed frame is not a valid response to the command that was sent
+var (
+	ErrAddressMismatch = shdlc.ErrAddressMismatch
+	ErrCommandMismatch = shdlc.ErrCommandMismatch
+	ErrDataTooLong     = errors.New("rx frame contains more data than expected")
+)
+
+// ErrNoNewData is returned by ReadMeasurement when the device has not measured new values since the last read.
+// It is expected when reading more often than once per second and is not a failure.
+var ErrNoNewData = errors.New("no new measurement values available")
+
+// DeviceError is returned when the device executes a command and responds with a non-zero state.
+// Use errors.As to retrieve it and inspect the State against the device's error codes.
+type DeviceError = shdlc.StateError
 
 // header of a frame sent from the sps30 sensor
 type shdlcRxHeader struct {
@@ -49,10 +94,28 @@ type VersionInfo struct {
 	SHDLCMinor      uint8
 }
 
+// DeviceInfo holds the product type and serial number reported by the device
+type DeviceInfo struct {
+	ProductType  string
+	SerialNumber string
+}
+
+// StatusRegister holds the device status register and its decoded flags.
+// FanSpeedWarning is cleared automatically once the fan speed is back in range,
+// while LaserFailure and FanFailure remain set until the register is cleared.
+type StatusRegister struct {
+	Raw             uint32
+	FanSpeedWarning bool // fan speed is too high or too low
+	LaserFailure    bool // laser current is out of range
+	FanFailure      bool // fan is switched on but not turning, or the measured speed is 0 RPM
+}
+
 // Measurement holds the particulate matter(PM) values measured for varying sizes.
 // MC refers Mass concentration measured in µg/m³
 // NC refers particle count measure in #/cm³
-// Typical Particle size average particle diameter  measured in nm
+// Typical Particle size average particle diameter measured in µm
+// Values read in OutputFormatUint16 are whole numbers, except the typical particle size
+// which the device reports in nm and is converted to µm.
 type Measurement struct {
 	Mc1p0               float32
 	Mc2p5               float32
@@ -67,49 +130,145 @@ type Measurement struct {
 }
 
 // Device represesnts the SPS30 device
+//
+// A sensor in Sleep-mode only accepts a Wakeup, and Sleep is only accepted in Idle-mode.
+// To put a measuring sensor to sleep and resume measuring afterwards use:
+//
+//	StopMeasurement -> Sleep -> Wakeup -> StartMeasurement
+//
+// A Device is safe for concurrent use by multiple goroutines. Each command holds the device's lock
+// from sending its request until its response has been received, so commands from concurrent callers,
+// including a running Stream, are executed one after the other and never interleave on the UART.
+// Devices on the same Bus share that lock, and copies of a Device share its lock and state.
 type Device struct {
-	uart serial.Port
+	mu      *sync.Mutex
+	uart    Transport
+	client  *shdlc.Client
+	addr    uint8
+	timeout time.Duration
+	state   *deviceState
+	logger  Logger
+	stats   *deviceStats
+	shared  bool // the transport belongs to a Bus
+}
+
+// deviceState is the part of a Device that changes while it is used. It is guarded by mu and
+// shared by copies of the Device, like the lock itself.
+type deviceState struct {
+	start_format OutputFormat
+	format       OutputFormat
+	measuring    bool
+	sleeping     bool // the UART is off until a Wakeup
+	retry        RetryPolicy
+}
+
+// New creates and initialises a new SPS30 Device communicating over uart, configured by opts.
+// A serial.Port opened with 115200 baud, 8 data bits, no parity and one stop bit satisfies Transport.
+func New(uart Transport, opts ...Option) Device {
+	return newDevice(uart, new(sync.Mutex), shdlc.NewClient(uart, 0), newConfig(opts))
 }
 
-// New creates and initialises a new SPS30 Device
-func New(uart serial.Port) Device {
+// newDevice creates a Device talking through client on uart, serialized by mu
+func newDevice(uart Transport, mu *sync.Mutex, client *shdlc.Client, c config) Device {
 	return Device{
-		uart: uart,
+		mu:      mu,
+		uart:    uart,
+		client:  client,
+		addr:    c.addr,
+		timeout: c.timeout,
+		state: &deviceState{
+			start_format: c.format,
+			format:       c.format,
+			retry:        c.retry,
+		},
+		logger: c.logger,
+		stats:  new(deviceStats),
 	}
 }
 
 // Wakeup switches the device from sleep-mode to idle mode
 func (d *Device) Wakeup() error {
+	return d.WakeupContext(context.Background())
+}
+
+// WakeupContext is like Wakeup but gives up once ctx is done.
+func (d *Device) WakeupContext(ctx context.Context) error {
 	data := []byte{0xff}
 
+	// the wake-up pulse must directly precede the wake-up command
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	_, err := d.uart.Write(data)
 	if err != nil {
-		return errors.New("unable to WakeUP SPS30 device")
+		return fmt.Errorf("unable to WakeUP SPS30 device: %w", err)
 	}
 	rx_header := shdlcRxHeader{}
 	rdata := make([]byte, 0)
 
-	return d.SHDLCTransmitReceive(peripheralAddr, CmdWakeUp, 0, nil, 0, &rx_header, &rdata)
+	err = d.transceive(ctx, d.addr, CmdWakeUp, 0, nil, 0, &rx_header, &rdata)
+	if err != nil {
+		return err
+	}
+
+	d.state.sleeping = false
+
+	return nil
+}
+
+// Sleep switches the device from idle mode to sleep-mode, turning off the fan, laser and UART.
+// The device must be in idle mode; a measuring device rejects the command with
+// "Command not allowed in current state". Use Wakeup to leave sleep-mode.
+func (d *Device) Sleep() error {
+	return d.SleepContext(context.Background())
+}
+
+// SleepContext is like Sleep but gives up once ctx is done.
+func (d *Device) SleepContext(ctx context.Context) error {
+	rx_header := shdlcRxHeader{}
+	data := make([]byte, 0)
+
+	err := d.SHDLCTransmitReceiveContext(ctx, d.addr, cmdSleep, 0, nil, 0, &rx_header, &data)
+
+	if err != nil {
+		return fmt.Errorf("could not put device to sleep: %w", err)
+	}
+
+	if rx_header.state != 0 {
+		return &DeviceError{Cmd: rx_header.cmd, State: rx_header.state}
+	}
+
+	d.mu.Lock()
+	d.state.measuring = false
+	d.state.sleeping = true
+	d.mu.Unlock()
+
+	return nil
 }
 
 // ReadVersion populates a VersionInfo struct with version information about the firmware, hardware, and SHDLC protocol
 func (d *Device) ReadVersion(version_info *VersionInfo) error {
+	return d.ReadVersionContext(context.Background(), version_info)
+}
+
+// ReadVersionContext is like ReadVersion but gives up once ctx is done.
+func (d *Device) ReadVersionContext(ctx context.Context, version_info *VersionInfo) error {
 
 	rx_header := shdlcRxHeader{}
 	data := make([]byte, 7)
 
-	err := d.SHDLCTransmitReceive(peripheralAddr, cmdReadVersion, 0, nil, uint8(len(data)), &rx_header, &data)
+	err := d.SHDLCTransmitReceiveContext(ctx, d.addr, cmdReadVersion, 0, nil, uint8(len(data)), &rx_header, &data)
 
 	if err != nil {
-		return fmt.Errorf("could not read version info from device: %v", err)
+		return fmt.Errorf("could not read version info from device: %w", err)
 	}
 
-	if int(rx_header.data_len) != len(data) {
-		return errors.New("did not receive enough data from device when reading version info")
+	if rx_header.state != 0 {
+		return &DeviceError{Cmd: rx_header.cmd, State: rx_header.state}
 	}
 
-	if rx_header.state != 0 {
-		return fmt.Errorf("invalid results received from device. Reason: %v", errorMap[int(rx_header.state)])
+	if int(rx_header.data_len) != len(data) {
+		return errors.New("did not receive enough data from device when reading version info")
 	}
 
 	version_info.FirmwarMajor = data[0]
@@ -121,211 +280,553 @@ func (d *Device) ReadVersion(version_info *VersionInfo) error {
 	return nil
 }
 
-// StartMeasurement puts the SPS30 in Measure-mode.
-func (d *Device) StartMeasurement() error {
+// ReadDeviceInfo reads the product type and serial number of the device
+func (d *Device) ReadDeviceInfo() (DeviceInfo, error) {
+	return d.ReadDeviceInfoContext(context.Background())
+}
+
+// ReadDeviceInfoContext is like ReadDeviceInfo but gives up once ctx is done.
+func (d *Device) ReadDeviceInfoContext(ctx context.Context) (DeviceInfo, error) {
+	product_type, err := d.readDeviceInfoString(ctx, deviceInfoProductType)
+	if err != nil {
+		return DeviceInfo{}, fmt.Errorf("could not read product type from device: %w", err)
+	}
+
+	serial_number, err := d.readDeviceInfoString(ctx, deviceInfoSerialNumber)
+	if err != nil {
+		return DeviceInfo{}, fmt.Errorf("could not read serial number from device: %w", err)
+	}
+
+	return DeviceInfo{
+		ProductType:  product_type,
+		SerialNumber: serial_number,
+	}, nil
+}
+
+func (d *Device) readDeviceInfoString(ctx context.Context, subcmd uint8) (string, error) {
 	rx_header := shdlcRxHeader{}
-	subcmd := []byte{0x01, 0x03}
-	data := make([]byte, 0)
+	data := make([]byte, deviceInfoMaxLen)
+
+	err := d.SHDLCTransmitReceiveContext(ctx, d.addr, cmdDeviceInfo, 1, []byte{subcmd}, uint8(len(data)), &rx_header, &data)
+
+	if err != nil {
+		return "", err
+	}
+
+	if rx_header.state != 0 {
+		return "", &DeviceError{Cmd: rx_header.cmd, State: rx_header.state}
+	}
 
-	return d.SHDLCTransmitReceive(peripheralAddr, cmdStartMeasurement, uint8(len(subcmd)), subcmd, 0, &rx_header, &data)
+	return bytesString(data[:rx_header.data_len]), nil
 }
 
-// ReadMeasurement reads measurement values  from sps30 device
-func (d *Device) ReadMeasurement(measurement *Measurement) error {
+// ReadStatusRegister reads the device status register, optionally clearing it afterwards.
+func (d *Device) ReadStatusRegister(clear bool) (StatusRegister, error) {
+	return d.ReadStatusRegisterContext(context.Background(), clear)
+}
+
+// ReadStatusRegisterContext is like ReadStatusRegister but gives up once ctx is done.
+func (d *Device) ReadStatusRegisterContext(ctx context.Context, clear bool) (StatusRegister, error) {
 	rx_header := shdlcRxHeader{}
-	data := make([]byte, 40)
+	subcmd := []byte{0x00}
+	data := make([]byte, 5)
+
+	if clear {
+		subcmd[0] = 0x01
+	}
 
-	err := d.SHDLCTransmitReceive(peripheralAddr, CmdReadMeasurement, 0, nil, uint8(len(data)), &rx_header, &data)
+	err := d.SHDLCTransmitReceiveContext(ctx, d.addr, cmdReadStatusRegister, uint8(len(subcmd)), subcmd, uint8(len(data)), &rx_header, &data)
 
 	if err != nil {
-		return fmt.Errorf("could not read measurement from device: %v", err)
+		return StatusRegister{}, fmt.Errorf("could not read status register from device: %w", err)
+	}
+
+	if rx_header.state != 0 {
+		return StatusRegister{}, &DeviceError{Cmd: rx_header.cmd, State: rx_header.state}
 	}
 
 	if int(rx_header.data_len) != len(data) {
-		return errors.New("did not receive enough data from device when reading measurements")
+		return StatusRegister{}, errors.New("did not receive enough data from device when reading status register")
+	}
+
+	raw := binary.BigEndian.Uint32(data[0:4])
+
+	return StatusRegister{
+		Raw:             raw,
+		FanSpeedWarning: raw&statusFanSpeedWarning != 0,
+		LaserFailure:    raw&statusLaserFailure != 0,
+		FanFailure:      raw&statusFanFailure != 0,
+	}, nil
+}
+
+// Reset performs a soft reset of the device, returning it to the same state as after a power-up.
+// It waits for the device to boot and discards any data left in the UART input buffer.
+func (d *Device) Reset() error {
+	return d.ResetContext(context.Background())
+}
+
+// ResetContext is like Reset but gives up once ctx is done.
+func (d *Device) ResetContext(ctx context.Context) error {
+	rx_header := shdlcRxHeader{}
+	data := make([]byte, 0)
+
+	// no other command may be sent while the device is booting
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := d.transceive(ctx, d.addr, cmdReset, 0, nil, 0, &rx_header, &data)
+
+	if err != nil {
+		return fmt.Errorf("could not reset device: %w", err)
 	}
 
 	if rx_header.state != 0 {
-		return fmt.Errorf("invalid results received from device. Reason: %v", errorMap[int(rx_header.state)])
+		return &DeviceError{Cmd: rx_header.cmd, State: rx_header.state}
 	}
 
-	(*measurement).Mc1p0 = bytesFloat32(data[0:4])
-	(*measurement).Mc2p5 = bytesFloat32(data[4:8])
-	(*measurement).Mc4p0 = bytesFloat32(data[8:12])
-	(*measurement).Mc10p0 = bytesFloat32(data[12:16])
-	(*measurement).Nc0p5 = bytesFloat32(data[16:20])
-	(*measurement).Nc1p0 = bytesFloat32(data[20:24])
-	(*measurement).Nc2p5 = bytesFloat32(data[24:28])
-	(*measurement).Nc4p0 = bytesFloat32(data[28:32])
-	(*measurement).Nc10p0 = bytesFloat32(data[32:36])
-	(*measurement).TypicalParticleSize = bytesFloat32(data[36:40])
+	d.state.measuring = false
+	d.state.sleeping = false
+
+	select {
+	case <-time.After(resetBootTime):
+	case <-ctx.Done():
+		return fmt.Errorf("interrupted while waiting for device to boot: %w", ctx.Err())
+	}
+
+	err = d.resetInputBuffer()
+	if err != nil {
+		return fmt.Errorf("could not reset UART input buffer: %w", err)
+	}
 
 	return nil
 }
 
-func bytesFloat32(bytes []byte) float32 {
-	bits := binary.BigEndian.Uint32(bytes)
-	float := math.Float32frombits(bits)
-	return float
+// StartMeasurement puts the SPS30 in Measure-mode, reporting values as floats unless
+// another format was set with WithMeasurementFormat.
+func (d *Device) StartMeasurement() error {
+	return d.StartMeasurementContext(context.Background())
 }
 
-// SHDLCTransmitReceive transmits SHDLC Frame to the device, and populates rx_header and rx_data with the response.
-func (d *Device) SHDLCTransmitReceive(addr uint8,
-	cmd uint8, tx_data_len uint8,
-	tx_data []byte,
-	max_rx_data_len uint8,
-	rx_header *shdlcRxHeader,
-	rx_data *[]byte) error {
-	// transcieve (transmit then receive) and SHDLC Frame
+// StartMeasurementContext is like StartMeasurement but gives up once ctx is done.
+func (d *Device) StartMeasurementContext(ctx context.Context) error {
+	return d.StartMeasurementFormatContext(ctx, d.state.start_format)
+}
+
+// StartMeasurementFormat puts the SPS30 in Measure-mode, reporting values in the given format.
+// ReadMeasurement decodes values using the format the measurement was last started with. If the
+// device is already measuring in another format, that format is kept for ReadMeasurement and an error
+// is returned.
+func (d *Device) StartMeasurementFormat(format OutputFormat) error {
+	return d.StartMeasurementFormatContext(context.Background(), format)
+}
+
+// StartMeasurementFormatContext is like StartMeasurementFormat but gives up once ctx is done.
+func (d *Device) StartMeasurementFormatContext(ctx context.Context, format OutputFormat) error {
+	if format != OutputFormatFloat && format != OutputFormatUint16 {
+		return fmt.Errorf("unsupported measurement output format 0x%02x", uint8(format))
+	}
+
+	rx_header := shdlcRxHeader{}
+	subcmd := []byte{0x01, uint8(format)}
+	data := make([]byte, 0)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := d.transceive(ctx, d.addr, cmdStartMeasurement, uint8(len(subcmd)), subcmd, 0, &rx_header, &data)
 
-	err := d.shdlcTx(addr, cmd, tx_data_len, tx_data)
 	if err != nil {
-		return fmt.Errorf("shdlc XCV failed: %v", err)
+		return err
 	}
 
-	return d.shdlcRx(int(max_rx_data_len), rx_header, rx_data)
+	switch rx_header.state {
+	case 0:
+		d.state.format = format
+		d.state.measuring = true
+		return nil
+	case stateCommandNotAllowed:
+		// the device is most likely measuring already, which the probe confirms
+		active, measuring, err := d.probeMeasuring(ctx, format)
+		if err != nil {
+			return err
+		}
+		if !measuring {
+			break
+		}
+
+		// a device that is already measuring keeps reporting in the format it was started with
+		d.state.measuring = true
+		d.state.format = active
+		if active != format {
+			return fmt.Errorf("device is already measuring in output format 0x%02x, stop the measurement to change it", uint8(active))
+		}
+		return nil
+	}
+
+	return &DeviceError{Cmd: rx_header.cmd, State: rx_header.state}
 }
 
-func (d *Device) shdlcTx(addr uint8, cmd uint8, data_len uint8, data []byte) error {
+// probeMeasuring reports whether the device is in Measurement-mode, which is the only mode that
+// accepts a read measurement command, and the output format it reports in. The format is told
+// apart by the length of the values, and expected is returned if there are no new values yet.
+// The caller must hold d.mu.
+func (d *Device) probeMeasuring(ctx context.Context, expected OutputFormat) (OutputFormat, bool, error) {
+	rx_header := shdlcRxHeader{}
+	data := make([]byte, measurementDataLen(OutputFormatFloat))
 
-	var tx_frame = [shdlcFrameMaxTxFrameSize]byte{}
-	len := 0
+	err := d.transceive(ctx, d.addr, CmdReadMeasurement, 0, nil, uint8(len(data)), &rx_header, &data)
 
-	crc := shdlcCRC(addr+cmd, data_len, data)
+	if err != nil {
+		return 0, false, err
+	}
+	if rx_header.state != 0 {
+		return 0, false, nil
+	}
 
-	tx_frame[len] = shdlcStart
-	len += 1
+	switch int(rx_header.data_len) {
+	case measurementDataLen(OutputFormatFloat):
+		return OutputFormatFloat, true, nil
+	case measurementDataLen(OutputFormatUint16):
+		return OutputFormatUint16, true, nil
+	}
 
-	len = stuffData(1, []byte{addr}, &tx_frame, len)
-	len = stuffData(1, []byte{cmd}, &tx_frame, len)
-	len = stuffData(1, []byte{data_len}, &tx_frame, len)
-	len = stuffData(int(data_len), data, &tx_frame, len)
+	return expected, true, nil
+}
 
-	tx_frame[len] = crc
-	len += 1
+// StopMeasurement stops the measurement and returns the SPS30 to Idle-mode.
+func (d *Device) StopMeasurement() error {
+	return d.StopMeasurementContext(context.Background())
+}
 
-	tx_frame[len] = shdlcStop
-	len += 1
+// StopMeasurementContext is like StopMeasurement but gives up once ctx is done.
+func (d *Device) StopMeasurementContext(ctx context.Context) error {
+	rx_header := shdlcRxHeader{}
+	data := make([]byte, 0)
 
-	_, err := d.uart.Write(tx_frame[:len])
+	err := d.SHDLCTransmitReceiveContext(ctx, d.addr, cmdStopMeasurement, 0, nil, 0, &rx_header, &data)
 
 	if err != nil {
-		return fmt.Errorf("unable send data to sensor: %v", err)
+		return fmt.Errorf("could not stop measurement: %w", err)
 	}
 
+	if rx_header.state != 0 {
+		return &DeviceError{Cmd: rx_header.cmd, State: rx_header.state}
+	}
+
+	d.setMeasuring(false)
+
 	return nil
 }
 
-func (d *Device) shdlcRx(max_data_len int, rx_header *shdlcRxHeader, data *[]byte) error {
+// ReadMeasurement reads measurement values  from sps30 device.
+// ErrNoNewData is returned if no new values were measured since the last read.
+func (d *Device) ReadMeasurement(measurement *Measurement) error {
+	return d.ReadMeasurementContext(context.Background(), measurement)
+}
+
+// ReadMeasurementContext is like ReadMeasurement but gives up once ctx is done.
+func (d *Device) ReadMeasurementContext(ctx context.Context, measurement *Measurement) error {
+	rx_header := shdlcRxHeader{}
 
-	rx_frame := make([]byte, shdlcFrameMaxRxFrameSize)
-	header_index := 0
-	data_index := 0
-	var crc uint8
+	// the format must not change between sending the command and decoding the response
+	d.mu.Lock()
+	format := d.state.format
+	data := make([]byte, measurementDataLen(format))
 
-	frame_len, err := d.uart.Read(rx_frame)
+	err := d.transceive(ctx, d.addr, CmdReadMeasurement, 0, nil, uint8(len(data)), &rx_header, &data)
+	d.mu.Unlock()
 
 	if err != nil {
-		return fmt.Errorf("failed to read data from sensor: %v", err)
+		return fmt.Errorf("could not read measurement from device: %w", err)
 	}
 
-	if frame_len < 1 || rx_frame[0] != shdlcStart {
+	if rx_header.state != 0 {
+		return &DeviceError{Cmd: rx_header.cmd, State: rx_header.state}
+	}
 
-		return errors.New("missing SHDLC Start byte in Rx frame")
+	// the device responds without data if no new values were measured since the last read
+	if rx_header.data_len == 0 {
+		return ErrNoNewData
+	}
+
+	if int(rx_header.data_len) != len(data) {
+		return errors.New("did not receive enough data from device when reading measurements")
 	}
 
-	// get Frame Header
-	header_index = 1
-	header_index = unstuffByte(rx_frame, header_index, &rx_header.addr)
-	header_index = unstuffByte(rx_frame, header_index, &rx_header.cmd)
-	header_index = unstuffByte(rx_frame, header_index, &rx_header.state)
-	header_index = unstuffByte(rx_frame, header_index, &rx_header.data_len)
+	decodeMeasurement(format, data, measurement)
 
-	if len(*data) < int(rx_header.data_len) {
-		return errors.New("rx frame contains more data than expected")
+	return nil
+}
+
+// StartFanCleaning starts the fan-cleaning manually. The device must be in Measure-mode,
+// and no new measurement values are available while the fan is cleaning (about 10 seconds).
+func (d *Device) StartFanCleaning() error {
+	return d.StartFanCleaningContext(context.Background())
+}
+
+// StartFanCleaningContext is like StartFanCleaning but gives up once ctx is done.
+func (d *Device) StartFanCleaningContext(ctx context.Context) error {
+	rx_header := shdlcRxHeader{}
+	data := make([]byte, 0)
+
+	err := d.SHDLCTransmitReceiveContext(ctx, d.addr, cmdStartFanCleaning, 0, nil, 0, &rx_header, &data)
+
+	if err != nil {
+		return fmt.Errorf("could not start fan cleaning: %w", err)
 	}
-	data_index = header_index
-	i := 0
 
-	for data_index < frame_len-2 && i < max_data_len {
-		data_index = unstuffByte(rx_frame, data_index, &(*data)[i])
-		i += 1
+	if rx_header.state != 0 {
+		return &DeviceError{Cmd: rx_header.cmd, State: rx_header.state}
 	}
 
-	crc = shdlcCRC(rx_header.addr+rx_header.cmd+rx_header.state, rx_header.data_len, *data)
-	if crc != rx_frame[data_index] {
-		return errors.New("mismatch in CRC")
+	return nil
+}
+
+// ReadAutoCleaningInterval reads the interval at which the device automatically cleans its fan.
+// An interval of 0 means auto cleaning is disabled.
+func (d *Device) ReadAutoCleaningInterval() (time.Duration, error) {
+	return d.ReadAutoCleaningIntervalContext(context.Background())
+}
+
+// ReadAutoCleaningIntervalContext is like ReadAutoCleaningInterval but gives up once ctx is done.
+func (d *Device) ReadAutoCleaningIntervalContext(ctx context.Context) (time.Duration, error) {
+	rx_header := shdlcRxHeader{}
+	subcmd := []byte{0x00}
+	data := make([]byte, 4)
+
+	err := d.SHDLCTransmitReceiveContext(ctx, d.addr, cmdAutoCleaningInterval, uint8(len(subcmd)), subcmd, uint8(len(data)), &rx_header, &data)
+
+	if err != nil {
+		return 0, fmt.Errorf("could not read auto cleaning interval from device: %w", err)
+	}
+
+	if rx_header.state != 0 {
+		return 0, &DeviceError{Cmd: rx_header.cmd, State: rx_header.state}
 	}
-	data_index += 1
 
-	if data_index >= frame_len || rx_frame[data_index] != shdlcStop {
-		return errors.New("missing SHDLC STOP byte")
+	if int(rx_header.data_len) != len(data) {
+		return 0, errors.New("did not receive enough data from device when reading auto cleaning interval")
+	}
+
+	return time.Duration(binary.BigEndian.Uint32(data)) * time.Second, nil
+}
+
+// WriteAutoCleaningInterval sets the interval at which the device automatically cleans its fan.
+// The interval is stored with a resolution of one second, must be between 0 and math.MaxUint32 seconds,
+// and an interval of 0 disables auto cleaning.
+func (d *Device) WriteAutoCleaningInterval(interval time.Duration) error {
+	return d.WriteAutoCleaningIntervalContext(context.Background(), interval)
+}
+
+// WriteAutoCleaningIntervalContext is like WriteAutoCleaningInterval but gives up once ctx is done.
+func (d *Device) WriteAutoCleaningIntervalContext(ctx context.Context, interval time.Duration) error {
+	if interval < 0 || interval/time.Second > math.MaxUint32 {
+		return fmt.Errorf("auto cleaning interval %v out of range [0s, %ds]", interval, uint32(math.MaxUint32))
+	}
+
+	rx_header := shdlcRxHeader{}
+	subcmd := make([]byte, 5)
+	binary.BigEndian.PutUint32(subcmd[1:], uint32(interval/time.Second))
+	data := make([]byte, 0)
+
+	err := d.SHDLCTransmitReceiveContext(ctx, d.addr, cmdAutoCleaningInterval, uint8(len(subcmd)), subcmd, 0, &rx_header, &data)
+
+	if err != nil {
+		return fmt.Errorf("could not write auto cleaning interval to device: %w", err)
+	}
+
+	if rx_header.state != 0 {
+		return &DeviceError{Cmd: rx_header.cmd, State: rx_header.state}
 	}
 
 	return nil
 }
 
-func unstuffByte(data []byte, index int, value *uint8) int {
-
-	if data[index] == 0x7d {
-		switch data[index+1] {
-		case 0x31:
-			(*value) = 0x11
-		case 0x33:
-			(*value) = 0x13
-		case 0x5D:
-			(*value) = 0x7D
-		case 0x5E:
-			(*value) = 0x7E
-		default:
-			(*value) = data[index+1]
-		}
-		index += 2
-		return index
-
-	} else {
-		(*value) = data[index]
-		index += 1
-		return index
-	}
-}
-
-func stuffData(data_len int, data []byte, stuffed_data *[shdlcFrameMaxTxFrameSize]byte, index int) int {
-
-	for i := 0; i < data_len; i++ {
-		switch data[i] {
-		case 0x11:
-			(*stuffed_data)[index] = 0x7D
-			(*stuffed_data)[index+1] = 0x31
-			index += 2
-		case 0x13:
-			(*stuffed_data)[index] = 0x7D
-			(*stuffed_data)[index+1] = 0x33
-			index += 2
-		case 0x7D:
-			(*stuffed_data)[index] = 0x7D
-			(*stuffed_data)[index+1] = 0x5D
-			index += 2
-		case 0x7E:
-			(*stuffed_data)[index] = 0x7D
-			(*stuffed_data)[index+1] = 0x5E
-			index += 2
-		default:
-			(*stuffed_data)[index] = data[i]
-			index += 1
+// setMeasuring records whether the device is in Measure-mode
+func (d *Device) setMeasuring(measuring bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.state.measuring = measuring
+}
+
+// outputFormat returns the format the measurement was last started with
+func (d *Device) outputFormat() OutputFormat {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.state.format
+}
+
+// measurementDataLen returns the size of the measured values encoded in format
+func measurementDataLen(format OutputFormat) int {
+	if format == OutputFormatUint16 {
+		return 20
+	}
+	return 40
+}
+
+// decodeMeasurement decodes the measured values in data, which the device encoded in format
+func decodeMeasurement(format OutputFormat, data []byte, measurement *Measurement) {
+	if format == OutputFormatUint16 {
+		(*measurement).Mc1p0 = bytesUint16Float32(data[0:2])
+		(*measurement).Mc2p5 = bytesUint16Float32(data[2:4])
+		(*measurement).Mc4p0 = bytesUint16Float32(data[4:6])
+		(*measurement).Mc10p0 = bytesUint16Float32(data[6:8])
+		(*measurement).Nc0p5 = bytesUint16Float32(data[8:10])
+		(*measurement).Nc1p0 = bytesUint16Float32(data[10:12])
+		(*measurement).Nc2p5 = bytesUint16Float32(data[12:14])
+		(*measurement).Nc4p0 = bytesUint16Float32(data[14:16])
+		(*measurement).Nc10p0 = bytesUint16Float32(data[16:18])
+		(*measurement).TypicalParticleSize = bytesUint16Float32(data[18:20]) / 1000
+
+		return
+	}
+
+	(*measurement).Mc1p0 = bytesFloat32(data[0:4])
+	(*measurement).Mc2p5 = bytesFloat32(data[4:8])
+	(*measurement).Mc4p0 = bytesFloat32(data[8:12])
+	(*measurement).Mc10p0 = bytesFloat32(data[12:16])
+	(*measurement).Nc0p5 = bytesFloat32(data[16:20])
+	(*measurement).Nc1p0 = bytesFloat32(data[20:24])
+	(*measurement).Nc2p5 = bytesFloat32(data[24:28])
+	(*measurement).Nc4p0 = bytesFloat32(data[28:32])
+	(*measurement).Nc10p0 = bytesFloat32(data[32:36])
+	(*measurement).TypicalParticleSize = bytesFloat32(data[36:40])
+}
+
+func bytesFloat32(bytes []byte) float32 {
+	bits := binary.BigEndian.Uint32(bytes)
+	float := math.Float32frombits(bits)
+	return float
+}
+
+func bytesUint16Float32(bytes []byte) float32 {
+	return float32(binary.BigEndian.Uint16(bytes))
+}
+
+// bytesString converts a null-terminated ASCII payload to a string
+func bytesString(bytes []byte) string {
+	for i, b := range bytes {
+		if b == 0 {
+			return string(bytes[:i])
 		}
+	}
+	return string(bytes)
+}
+
+// Response is the response of the device to a command sent with Execute.
+// State is 0 if the device executed the command.
+type Response = shdlc.Response
+
+// Execute sends cmd with payload to the device and returns its response, for commands this package doesn't
+// implement, e.g. ones added by newer firmware. If the device did not execute the command, the response is
+// returned together with a *DeviceError. Execute doesn't know what the command does, so Stream and the
+// Close of a Device on a Bus don't notice a measurement started or stopped with it.
+func (d *Device) Execute(cmd byte, payload []byte) (Response, error) {
+	return d.ExecuteContext(context.Background(), cmd, payload)
+}
+
+// ExecuteContext is like Execute but gives up once ctx is done.
+func (d *Device) ExecuteContext(ctx context.Context, cmd byte, payload []byte) (Response, error) {
+	if len(payload) > shdlc.MaxDataLen {
+		return Response{}, fmt.Errorf("could not execute command 0x%02x: %w", cmd, shdlc.ErrDataTooLong)
+	}
+
+	rx_header := shdlcRxHeader{}
+	data := make([]byte, shdlc.MaxDataLen)
+
+	err := d.SHDLCTransmitReceiveContext(ctx, d.addr, cmd, uint8(len(payload)), payload, shdlc.MaxDataLen, &rx_header, &data)
+
+	if err != nil {
+		return Response{}, fmt.Errorf("could not execute command 0x%02x: %w", cmd, err)
+	}
 
+	response := Response{
+		Addr:  rx_header.addr,
+		Cmd:   rx_header.cmd,
+		State: rx_header.state,
+		Data:  data[:rx_header.data_len],
 	}
-	return index
+
+	return response, response.Err()
 }
 
-func shdlcCRC(headerSum uint8, dataLen uint8, data []byte) uint8 {
+// SHDLCTransmitReceive transmits SHDLC Frame to the device, and populates rx_header and rx_data with the response.
+//
+// Deprecated: rx_header is of an unexported type, so SHDLCTransmitReceive can't be called from other packages. Use Execute.
+func (d *Device) SHDLCTransmitReceive(addr uint8,
+	cmd uint8, tx_data_len uint8,
+	tx_data []byte,
+	max_rx_data_len uint8,
+	rx_header *shdlcRxHeader,
+	rx_data *[]byte) error {
+	return d.SHDLCTransmitReceiveContext(context.Background(), addr, cmd, tx_data_len, tx_data, max_rx_data_len, rx_header, rx_data)
+}
+
+// SHDLCTransmitReceiveContext is like SHDLCTransmitReceive but gives up once ctx is done.
+// The device gets at most its response timeout to respond, or less if ctx has an earlier deadline.
+//
+// Deprecated: use ExecuteContext.
+func (d *Device) SHDLCTransmitReceiveContext(ctx context.Context,
+	addr uint8,
+	cmd uint8, tx_data_len uint8,
+	tx_data []byte,
+	max_rx_data_len uint8,
+	rx_header *shdlcRxHeader,
+	rx_data *[]byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-	sum := headerSum + dataLen
+	return d.transceive(ctx, addr, cmd, tx_data_len, tx_data, max_rx_data_len, rx_header, rx_data)
+}
+
+// transceive (transmit then receive) and SHDLC Frame, retrying according to the device's RetryPolicy.
+// The caller must hold d.mu.
+func (d *Device) transceive(ctx context.Context,
+	addr uint8,
+	cmd uint8, tx_data_len uint8,
+	tx_data []byte,
+	max_rx_data_len uint8,
+	rx_header *shdlcRxHeader,
+	rx_data *[]byte) error {
 
-	for i := 0; i < int(dataLen); i++ {
-		sum += data[i]
+	return d.withRetry(ctx, func() error {
+		return d.transceiveOnce(ctx, addr, cmd, tx_data_len, tx_data, max_rx_data_len, rx_header, rx_data)
+	})
+}
+
+func (d *Device) transceiveOnce(ctx context.Context,
+	addr uint8,
+	cmd uint8, tx_data_len uint8,
+	tx_data []byte,
+	max_rx_data_len uint8,
+	rx_header *shdlcRxHeader,
+	rx_data *[]byte) error {
+
+	// the client is shared by all devices on a Bus, which may wait for responses differently
+	d.client.SetTimeout(d.timeout)
+
+	response, err := d.client.Transceive(ctx, shdlc.Request{Addr: addr, Cmd: cmd, Data: tx_data[:tx_data_len]})
+	if err != nil {
+		return fmt.Errorf("shdlc XCV failed: %w", err)
+	}
+
+	return setRx(response, int(max_rx_data_len), rx_header, rx_data)
+}
+
+// setRx fills rx_header and data from response, which must carry at most max_data_len bytes of data
+func setRx(response shdlc.Response, max_data_len int, rx_header *shdlcRxHeader, data *[]byte) error {
+	if len(response.Data) > max_data_len || len(response.Data) > len(*data) {
+		return fmt.Errorf("%w: got %d bytes, expected at most %d", ErrDataTooLong, len(response.Data), min(max_data_len, len(*data)))
 	}
 
-	return ^sum
+	rx_header.addr = response.Addr
+	rx_header.cmd = response.Cmd
+	rx_header.state = response.State
+	rx_header.data_len = uint8(len(response.Data))
+
+	copy(*data, response.Data)
+
+	return nil
 }
@@ -2,9 +2,16 @@ package sps30_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"os"
+	"os/signal"
+	"sync"
 	"testing"
 	"time"
 
@@ -34,8 +41,7 @@ func (f fakeUart) SetMode(mode *serial.Mode) error {
 	return nil
 }
 func (f fakeUart) Read(p []byte) (n int, err error) {
-	(*f.Data).Read(p)
-	return len(p), nil
+	return (*f.Data).Read(p)
 }
 
 func (f fakeUart) Drain() error {
@@ -70,6 +76,77 @@ func (uart fakeUart) Close() error {
 	return nil
 }
 
+// scriptedUart replies to each Read with the next canned frame and records every Write.
+type scriptedUart struct {
+	fakeUart
+	frames  *[][]byte
+	written *[][]byte
+	resets  *int
+	closes  *int
+}
+
+func newScriptedUart(frames ...[]byte) scriptedUart {
+	return scriptedUart{
+		fakeUart: fakeUart{Data: new(bytes.Buffer)},
+		frames:   &frames,
+		written:  &[][]byte{},
+		resets:   new(int),
+		closes:   new(int),
+	}
+}
+
+func (s scriptedUart) Close() error {
+	*s.closes += 1
+	return nil
+}
+
+func (s scriptedUart) ResetInputBuffer() error {
+	*s.resets += 1
+	return nil
+}
+
+func (s scriptedUart) Write(p []byte) (n int, err error) {
+	*s.written = append(*s.written, append([]byte{}, p...))
+	return len(p), nil
+}
+
+func (s scriptedUart) Read(p []byte) (n int, err error) {
+	if len(*s.frames) == 0 {
+		return 0, io.EOF
+	}
+	n = copy(p, (*s.frames)[0])
+	*s.frames = (*s.frames)[1:]
+	return n, nil
+}
+
+// dribbleUart returns at most one byte per Read, like a slow USB-serial adapter.
+// Once its data is exhausted every Read times out without returning data.
+type dribbleUart struct {
+	fakeUart
+	resets *int
+}
+
+func newDribbleUart(data []byte) dribbleUart {
+	return dribbleUart{fakeUart: fakeUart{Data: bytes.NewBuffer(data)}, resets: new(int)}
+}
+
+func (d dribbleUart) Write(p []byte) (n int, err error) {
+	return len(p), nil
+}
+
+func (d dribbleUart) ResetInputBuffer() error {
+	*d.resets += 1
+	(*d.Data).Reset()
+	return nil
+}
+
+func (d dribbleUart) Read(p []byte) (n int, err error) {
+	if len(p) == 0 || (*d.Data).Len() == 0 {
+		return 0, nil
+	}
+	return (*d.Data).Read(p[:1])
+}
+
 func TestWakeup(t *testing.T) {
 	tests := []struct {
 		uartBuffer []byte
@@ -86,6 +163,57 @@ func TestWakeup(t *testing.T) {
 		}
 	}
 }
+func TestSleep(t *testing.T) {
+	tests := []struct {
+		uartBuffer []byte
+		wantErr    bool
+	}{
+		{uartBuffer: []byte{0x7e, 0x00, 0x10, 0x00, 0x00, 0xef, 0x7e}, wantErr: false},
+		{ // Command not allowed in current state, device is not in idle mode
+			uartBuffer: []byte{0x7e, 0x00, 0x10, 0x43, 0x00, 0xac, 0x7e},
+			wantErr:    true,
+		},
+	}
+	for _, test := range tests {
+		mockUart := fakeUart{Data: bytes.NewBuffer(test.uartBuffer)}
+		device := sps30.New(mockUart)
+		err := device.Sleep()
+
+		if (err != nil) != test.wantErr {
+			t.Errorf("Sleep() error = %v, wantErr %v", err, test.wantErr)
+		}
+	}
+}
+
+func TestSleepWakeupStartMeasurement(t *testing.T) {
+	mockUart := newScriptedUart(
+		[]byte{0x7e, 0x00, 0x10, 0x00, 0x00, 0xef, 0x7e},       // Sleep
+		[]byte{0x7e, 0x00, 0x7d, 0x31, 0x00, 0x00, 0xee, 0x7e}, // Wake-up
+		[]byte{0x7e, 0x00, 0x00, 0x00, 0x00, 0xff, 0x7e},       // Start Measurement
+	)
+	device := sps30.New(mockUart)
+
+	if err := device.Sleep(); err != nil {
+		t.Fatalf("Sleep() failed: %v", err)
+	}
+	if err := device.Wakeup(); err != nil {
+		t.Fatalf("Wakeup() failed: %v", err)
+	}
+	if err := device.StartMeasurement(); err != nil {
+		t.Fatalf("StartMeasurement() failed: %v", err)
+	}
+
+	want := []string{"7e001000ef7e", "ff", "7e007d3100ee7e", "7e0000020103f97e"}
+	if len(*mockUart.written) != len(want) {
+		t.Fatalf("got %d writes, expected %d", len(*mockUart.written), len(want))
+	}
+	for i, w := range *mockUart.written {
+		if got := hex.EncodeToString(w); got != want[i] {
+			t.Errorf("write %d = 0x%v. Expected 0x%v", i, got, want[i])
+		}
+	}
+}
+
 func TestReadVersion(t *testing.T) {
 
 	tests := []struct {
@@ -107,23 +235,167 @@ func TestReadVersion(t *testing.T) {
 
 }
 
-func TestStartMeasurement(t *testing.T) {
+func TestReadDeviceInfo(t *testing.T) {
+	mockUart := newScriptedUart(
+		[]byte{0x7e, 0x00, 0xd0, 0x00, 0x09, 0x30, 0x30, 0x30, 0x38, 0x30, 0x30, 0x30, 0x30, 0x00, 0x9e, 0x7e},
+		[]byte{0x7e, 0x00, 0xd0, 0x00, 0x7d, 0x31, 0x38, 0x41, 0x35, 0x46, 0x32, 0x42, 0x31, 0x43, 0x33, 0x44, 0x34, 0x45, 0x36, 0x46, 0x37, 0x30, 0x00, 0x6f, 0x7e},
+	)
+	device := sps30.New(mockUart)
+	got, err := device.ReadDeviceInfo()
+
+	if err != nil {
+		t.Fatalf("ReadDeviceInfo() failed: %v", err)
+	}
+
+	want := sps30.DeviceInfo{ProductType: "00080000", SerialNumber: "8A5F2B1C3D4E6F70"}
+	if got != want {
+		t.Errorf("ReadDeviceInfo() = %+v. Expected %+v", got, want)
+	}
+
+	wantTx := []string{"7e00d001002e7e", "7e00d001032b7e"}
+	for i, w := range *mockUart.written {
+		if got := hex.EncodeToString(w); got != wantTx[i] {
+			t.Errorf("write %d = 0x%v. Expected 0x%v", i, got, wantTx[i])
+		}
+	}
+}
+
+func TestReadStatusRegister(t *testing.T) {
 	tests := []struct {
+		clear      bool
 		uartBuffer []byte
+		wantTx     string
+		want       sps30.StatusRegister
 	}{
-		{uartBuffer: []byte{0x7e, 0x00, 0x00, 0x43, 0x00, 0xbc, 0x7e}},
+		{
+			clear:      false,
+			uartBuffer: []byte{0x7e, 0x00, 0xd2, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x28, 0x7e},
+			wantTx:     "7e00d201002c7e",
+			want:       sps30.StatusRegister{},
+		},
+		{
+			clear:      false,
+			uartBuffer: []byte{0x7e, 0x00, 0xd2, 0x00, 0x05, 0x00, 0x20, 0x00, 0x00, 0x00, 0x08, 0x7e},
+			wantTx:     "7e00d201002c7e",
+			want:       sps30.StatusRegister{Raw: 0x00200000, FanSpeedWarning: true},
+		},
+		{
+			clear:      true,
+			uartBuffer: []byte{0x7e, 0x00, 0xd2, 0x00, 0x05, 0x00, 0x20, 0x00, 0x30, 0x00, 0xd8, 0x7e},
+			wantTx:     "7e00d201012b7e",
+			want:       sps30.StatusRegister{Raw: 0x00200030, FanSpeedWarning: true, LaserFailure: true, FanFailure: true},
+		},
 	}
 	for _, test := range tests {
-		mockUart := fakeUart{Data: bytes.NewBuffer(test.uartBuffer)}
+		mockUart := newScriptedUart(test.uartBuffer)
 		device := sps30.New(mockUart)
-		err := device.StartMeasurement()
+		got, err := device.ReadStatusRegister(test.clear)
 
 		if err != nil {
+			t.Errorf("ReadStatusRegister(%v) failed: %v", test.clear, err)
+		}
+		if got != test.want {
+			t.Errorf("ReadStatusRegister(%v) = %+v. Expected %+v", test.clear, got, test.want)
+		}
+		if tx := hex.EncodeToString((*mockUart.written)[0]); tx != test.wantTx {
+			t.Errorf("ReadStatusRegister(%v) sent 0x%v. Expected 0x%v", test.clear, tx, test.wantTx)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	tests := []struct {
+		uartBuffer []byte
+		wantErr    bool
+		wantResets int
+	}{
+		{uartBuffer: []byte{0x7e, 0x00, 0xd3, 0x00, 0x00, 0x2c, 0x7e}, wantErr: false, wantResets: 1},
+		{ // Command not allowed in current state
+			uartBuffer: []byte{0x7e, 0x00, 0xd3, 0x43, 0x00, 0xe9, 0x7e},
+			wantErr:    true,
+			wantResets: 0,
+		},
+	}
+	for _, test := range tests {
+		mockUart := newScriptedUart(test.uartBuffer)
+		device := sps30.New(mockUart)
+		err := device.Reset()
+
+		if (err != nil) != test.wantErr {
+			t.Errorf("Reset() error = %v, wantErr %v", err, test.wantErr)
+		}
+		if *mockUart.resets != test.wantResets {
+			t.Errorf("Reset() reset the input buffer %d times. Expected %d", *mockUart.resets, test.wantResets)
+		}
+	}
+}
+
+func TestStartMeasurement(t *testing.T) {
+	tests := []struct {
+		frames    [][]byte
+		wantState uint8
+	}{
+		{frames: [][]byte{{0x7e, 0x00, 0x00, 0x00, 0x00, 0xff, 0x7e}}},
+		{ // Command not allowed in current state, device is already measuring
+			frames: [][]byte{
+				{0x7e, 0x00, 0x00, 0x43, 0x00, 0xbc, 0x7e},
+				{0x7e, 0x00, 0x03, 0x00, 0x00, 0xfc, 0x7e},
+			},
+		},
+		{ // Command not allowed in current state, but the device is not measuring
+			frames: [][]byte{
+				{0x7e, 0x00, 0x00, 0x43, 0x00, 0xbc, 0x7e},
+				{0x7e, 0x00, 0x03, 0x43, 0x00, 0xb9, 0x7e},
+			},
+			wantState: 0x43,
+		},
+	}
+	for _, test := range tests {
+		mockUart := newScriptedUart(test.frames...)
+		device := sps30.New(mockUart)
+		err := device.StartMeasurement()
+
+		var device_err *sps30.DeviceError
+		switch {
+		case test.wantState == 0 && err != nil:
 			t.Errorf("StartMeasurement() failed: %v", err)
+		case test.wantState != 0 && !errors.As(err, &device_err):
+			t.Errorf("StartMeasurement() error = %v. Expected a DeviceError", err)
+		case test.wantState != 0 && device_err.State != test.wantState:
+			t.Errorf("StartMeasurement() state = 0x%02x. Expected 0x%02x", device_err.State, test.wantState)
 		}
 	}
 }
 
+func TestStopMeasurement(t *testing.T) {
+	tests := []struct {
+		uartBuffer []byte
+		wantErr    bool
+	}{
+		{uartBuffer: []byte{0x7e, 0x00, 0x01, 0x00, 0x00, 0xfe, 0x7e}, wantErr: false},
+		{ // Command not allowed in current state
+			uartBuffer: []byte{0x7e, 0x00, 0x01, 0x43, 0x00, 0xbb, 0x7e},
+			wantErr:    true,
+		},
+	}
+	for _, test := range tests {
+		mockUart := fakeUart{Data: bytes.NewBuffer(test.uartBuffer)}
+		device := sps30.New(mockUart)
+		err := device.StopMeasurement()
+
+		if (err != nil) != test.wantErr {
+			t.Errorf("StopMeasurement() error = %v, wantErr %v", err, test.wantErr)
+		}
+	}
+}
+
+// values of the float measurement frame used by the ReadMeasurement tests
+var floatMeasurement = sps30.Measurement{
+	Mc1p0: 0.0390643, Mc2p5: 0.053435143, Mc4p0: 0.063378, Mc10p0: 0.068498,
+	Nc0p5: 0.237524, Nc1p0: 0.2963334, Nc2p5: 0.30965897, Nc4p0: 0.31238893, Nc10p0: 0.3130958,
+	TypicalParticleSize: 0.7121593,
+}
+
 func TestReadMeasurement(t *testing.T) {
 	tests := []struct {
 		measurement *sps30.Measurement
@@ -142,297 +414,512 @@ func TestReadMeasurement(t *testing.T) {
 		if err != nil {
 			t.Errorf("StartMeasurement() failed: %v", err)
 		}
+		if *test.measurement != floatMeasurement {
+			t.Errorf("ReadMeasurement() = %+v. Expected %+v", *test.measurement, floatMeasurement)
+		}
 	}
 }
 
-func TestShdlcTx(t *testing.T) {
+func TestReadMeasurementNoNewData(t *testing.T) {
+	mockUart := newScriptedUart([]byte{0x7e, 0x00, 0x03, 0x00, 0x00, 0xfc, 0x7e})
+	device := sps30.New(mockUart)
+
+	err := device.ReadMeasurement(&sps30.Measurement{})
+	if !errors.Is(err, sps30.ErrNoNewData) {
+		t.Errorf("ReadMeasurement() error = %v. Expected %v", err, sps30.ErrNoNewData)
+	}
+}
+
+func TestReadMeasurementUint16(t *testing.T) {
+	mockUart := newScriptedUart(
+		[]byte{0x7e, 0x00, 0x00, 0x00, 0x00, 0xff, 0x7e},
+		[]byte{0x7e, 0x00, 0x03, 0x00, 0x14, 0x00, 0x0c, 0x00, 0x0f, 0x00, 0x7d, 0x31, 0x00, 0x12, 0x00, 0x50, 0x00, 0x5f, 0x00, 0x61, 0x00, 0x62, 0x00, 0x62, 0x02, 0x08, 0xcc, 0x7e},
+	)
+	device := sps30.New(mockUart)
+
+	if err := device.StartMeasurementFormat(sps30.OutputFormatUint16); err != nil {
+		t.Fatalf("StartMeasurementFormat(OutputFormatUint16) failed: %v", err)
+	}
+	if got := hex.EncodeToString((*mockUart.written)[0]); got != "7e0000020105f77e" {
+		t.Errorf("StartMeasurementFormat(OutputFormatUint16) sent 0x%v. Expected 0x7e0000020105f77e", got)
+	}
+
+	measurement := sps30.Measurement{}
+	if err := device.ReadMeasurement(&measurement); err != nil {
+		t.Fatalf("ReadMeasurement() failed: %v", err)
+	}
+
+	want := sps30.Measurement{
+		Mc1p0: 12, Mc2p5: 15, Mc4p0: 17, Mc10p0: 18,
+		Nc0p5: 80, Nc1p0: 95, Nc2p5: 97, Nc4p0: 98, Nc10p0: 98,
+		TypicalParticleSize: 0.52,
+	}
+	if measurement != want {
+		t.Errorf("ReadMeasurement() = %+v. Expected %+v", measurement, want)
+	}
+}
+
+func TestDeviceCopySharesState(t *testing.T) {
+	mockUart := newScriptedUart(
+		[]byte{0x7e, 0x00, 0x00, 0x00, 0x00, 0xff, 0x7e},
+		[]byte{0x7e, 0x00, 0x03, 0x00, 0x14, 0x00, 0x0c, 0x00, 0x0f, 0x00, 0x7d, 0x31, 0x00, 0x12, 0x00, 0x50, 0x00, 0x5f, 0x00, 0x61, 0x00, 0x62, 0x00, 0x62, 0x02, 0x08, 0xcc, 0x7e},
+	)
+	device := sps30.New(mockUart)
+	device_copy := device
+
+	if err := device_copy.StartMeasurementFormat(sps30.OutputFormatUint16); err != nil {
+		t.Fatalf("StartMeasurementFormat(OutputFormatUint16) failed: %v", err)
+	}
+
+	// the original must decode the values in the format the copy started the measurement with
+	measurement := sps30.Measurement{}
+	if err := device.ReadMeasurement(&measurement); err != nil {
+		t.Fatalf("ReadMeasurement() failed: %v", err)
+	}
+	if measurement.Mc1p0 != 12 || measurement.TypicalParticleSize != 0.52 {
+		t.Errorf("ReadMeasurement() = %+v. Expected the uint16 values", measurement)
+	}
+}
+
+func TestStartMeasurementAlreadyMeasuringUint16(t *testing.T) {
+	uint16Response := []byte{0x7e, 0x00, 0x03, 0x00, 0x14, 0x00, 0x0c, 0x00, 0x0f, 0x00, 0x7d, 0x31, 0x00, 0x12, 0x00, 0x50, 0x00, 0x5f, 0x00, 0x61, 0x00, 0x62, 0x00, 0x62, 0x02, 0x08, 0xcc, 0x7e}
+	mockUart := newScriptedUart(
+		[]byte{0x7e, 0x00, 0x00, 0x43, 0x00, 0xbc, 0x7e}, // Command not allowed in current state
+		uint16Response,
+		uint16Response,
+	)
+	device := sps30.New(mockUart)
+
+	// another program started the measurement in uint16 format
+	if err := device.StartMeasurement(); err == nil {
+		t.Errorf("StartMeasurement() succeeded although the device reports in another format")
+	}
+
+	measurement := sps30.Measurement{}
+	if err := device.ReadMeasurement(&measurement); err != nil {
+		t.Fatalf("ReadMeasurement() failed: %v", err)
+	}
+	if measurement.Mc1p0 != 12 || measurement.TypicalParticleSize != 0.52 {
+		t.Errorf("ReadMeasurement() = %+v. Expected the uint16 values", measurement)
+	}
+}
+
+func TestStartMeasurementFormatOldFirmware(t *testing.T) {
+	// firmware older than 2.0 rejects the uint16 format as an illegal command parameter
+	mockUart := newScriptedUart([]byte{0x7e, 0x00, 0x00, 0x04, 0x00, 0xfb, 0x7e})
+	device := sps30.New(mockUart)
+
+	err := device.StartMeasurementFormat(sps30.OutputFormatUint16)
+
+	var device_err *sps30.DeviceError
+	if !errors.As(err, &device_err) || device_err.State != 0x04 {
+		t.Errorf("StartMeasurementFormat(OutputFormatUint16) error = %v. Expected a DeviceError with state 0x04", err)
+	}
+}
+
+func TestStartMeasurementFormatInvalid(t *testing.T) {
+	mockUart := newScriptedUart()
+	device := sps30.New(mockUart)
+
+	if err := device.StartMeasurementFormat(0x04); err == nil {
+		t.Errorf("StartMeasurementFormat(0x04) succeeded. Expected an error")
+	}
+	if len(*mockUart.written) != 0 {
+		t.Errorf("StartMeasurementFormat(0x04) sent data to the device")
+	}
+}
+
+func TestStartFanCleaning(t *testing.T) {
 	tests := []struct {
-		addr    uint8
-		cmd     uint8
-		dataLen uint8
-		data    []byte
-		want    string
+		uartBuffer []byte
+		wantErr    bool
 	}{
-		{ // MOSI Start measurement
-			addr:    0x00,
-			cmd:     0x00,
-			dataLen: 0x02,
-			data:    []byte{0x01, 0x03},
-			want:    "7e0000020103f97e",
-		},
-		{ // MOSI Stop measurement
-			addr:    0x00,
-			cmd:     0x01,
-			dataLen: 0x00,
-			data:    []byte{},
-			want:    "7e000100fe7e",
-		},
-		{ // MOSI Read Measurement Value
-			addr:    0x00,
-			cmd:     0x03,
-			dataLen: 0x00,
-			data:    []byte{},
-			want:    "7e000300fc7e",
-		},
-		{ // MOSI Sleep
-			addr:    0x00,
-			cmd:     0x10,
-			dataLen: 0x00,
-			data:    []byte{},
-			want:    "7e001000ef7e",
-		},
-		{ // MOSI Device reset
-			addr:    0x00,
-			cmd:     0xd3,
-			dataLen: 0x00,
-			data:    []byte{},
-			want:    "7e00d3002c7e",
+		{uartBuffer: []byte{0x7e, 0x00, 0x56, 0x00, 0x00, 0xa9, 0x7e}, wantErr: false},
+		{ // Command not allowed in current state, device is not measuring
+			uartBuffer: []byte{0x7e, 0x00, 0x56, 0x43, 0x00, 0x66, 0x7e},
+			wantErr:    true,
 		},
 	}
-
 	for _, test := range tests {
-		mockUart := fakeUart{Data: new(bytes.Buffer)}
+		mockUart := fakeUart{Data: bytes.NewBuffer(test.uartBuffer)}
 		device := sps30.New(mockUart)
-		device.ShdlcTx(test.addr, test.cmd, test.dataLen, test.data)
+		err := device.StartFanCleaning()
 
-		got := hex.EncodeToString(mockUart.UartBuffer())
-		if got != test.want {
-			t.Errorf("ShdlcTx(0x%x, 0x%x, 0x%x, 0x%x) = 0x%v. Expected 0x%x", test.addr, test.cmd, test.dataLen, test.data, got, test.want)
+		if (err != nil) != test.wantErr {
+			t.Errorf("StartFanCleaning() error = %v, wantErr %v", err, test.wantErr)
 		}
-
 	}
 }
 
-func TestShdlcRx(t *testing.T) {
+func TestReadAutoCleaningInterval(t *testing.T) {
 	tests := []struct {
-		maxDataLen int
-		rxHeader   *sps30.ShdlcRxHeader
-		data       *[]byte
 		uartBuffer []byte
-		want       []byte
+		want       time.Duration
 	}{
-		{ // MISO Read measurement
-			maxDataLen: 0,
-			rxHeader:   &sps30.ShdlcRxHeader{},
-			data:       &[]byte{},
-			uartBuffer: []byte{0x7e, 0x00, 0x00, 0x00, 0x00, 0xFF, 0x7e},
-			want:       []byte{},
+		{ // default interval of one week
+			uartBuffer: []byte{0x7e, 0x00, 0x80, 0x00, 0x04, 0x00, 0x09, 0x3a, 0x80, 0xb8, 0x7e},
+			want:       7 * 24 * time.Hour,
 		},
-		{ // MISO Read Version, with byte stuffing
-			maxDataLen: 7,
-			rxHeader:   &sps30.ShdlcRxHeader{},
-			data:       &[]byte{0, 0, 0, 0, 0, 0, 0},
-			uartBuffer: []byte{0x7e, 0x00, 0xd1, 0x00, 0x07, 0x02, 0x7D, 0x31, 0x00, 0x07, 0x00, 0x02, 0x00, 0x0b, 0x7e},
-			want:       []byte{0x02, 0x11, 0x00, 0x07, 0x00, 0x02, 0x00},
-		},
-		{ // MISO Read Device Status Register
-			maxDataLen: 5,
-			rxHeader:   &sps30.ShdlcRxHeader{},
-			data:       &[]byte{0, 0, 0, 0, 0},
-			uartBuffer: []byte{0x7e, 0x00, 0xd2, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x28, 0x7e},
-			want:       []byte{0x00, 0x00, 0x00, 0x00, 0x00},
+		{ // auto cleaning disabled
+			uartBuffer: []byte{0x7e, 0x00, 0x80, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x7b, 0x7e},
+			want:       0,
 		},
 	}
-
 	for _, test := range tests {
-		mockUart := fakeUart{Data: bytes.NewBuffer(test.uartBuffer)}
+		mockUart := newScriptedUart(test.uartBuffer)
 		device := sps30.New(mockUart)
-		err := device.ShdlcRx(test.maxDataLen, test.rxHeader, test.data)
+		got, err := device.ReadAutoCleaningInterval()
 
 		if err != nil {
-			t.Error(err)
+			t.Errorf("ReadAutoCleaningInterval() failed: %v", err)
 		}
-
-		if !bytes.Equal((*test.data), test.want) {
-			t.Errorf("shdlcRx(0x%x, 0x%x, 0x%x) = 0x%x. Expected 0x%x", test.maxDataLen, test.rxHeader, test.data, (*test.data), test.want)
+		if got != test.want {
+			t.Errorf("ReadAutoCleaningInterval() = %v. Expected %v", got, test.want)
+		}
+		// the checksum of the request is 0x7e and must be stuffed
+		if got := hex.EncodeToString((*mockUart.written)[0]); got != "7e008001007d5e7e" {
+			t.Errorf("ReadAutoCleaningInterval() sent 0x%v. Expected 0x7e008001007d5e7e", got)
 		}
 	}
 }
-func TestShdlcCRC(t *testing.T) {
+
+func TestWriteAutoCleaningInterval(t *testing.T) {
 	tests := []struct {
-		addr    uint8
-		cmd     uint8
-		dataLen uint8
-		data    []byte
-		want    uint8
+		interval time.Duration
+		want     string
+		wantErr  bool
 	}{
-		{ // Stop Measurement
-			addr:    0x00,
-			cmd:     0x01,
-			dataLen: 0x00,
-			data:    []byte{},
-			want:    0xFE,
-		},
-		{ // Start Measurement
-			addr:    0x00,
-			cmd:     0x00,
-			dataLen: 2,
-			data:    []byte{0x01, 0x03},
-			want:    0xF9,
-		},
-		{ // Start Fan Cleaning
-			addr:    0x00,
-			cmd:     0x56,
-			dataLen: 0x00,
-			data:    []byte{},
-			want:    0xA9,
-		},
-		{ // non existing cmd
-			addr:    0xFF,
-			cmd:     0xFF,
-			dataLen: 0x01,
-			data:    []byte{0xFF, 0xFF, 0xFF},
-			want:    0x1,
-		},
-		{ // non existing cmd
-			addr:    0x20,
-			cmd:     0xF0,
-			dataLen: 0x04,
-			data:    []byte{0x50, 0x50, 0x50, 0x50},
-			want:    0xab,
-		},
-		{ // non existing cmd
-			addr:    0x20,
-			cmd:     0xF0,
-			dataLen: 0x04,
-			data:    []byte{0x50, 0x50, 0x50, 0x50},
-			want:    0xab,
-		},
+		{interval: 7 * 24 * time.Hour, want: "7e0080050000093a80b77e", wantErr: false},
+		{interval: 0, want: "7e00800500000000007a7e", wantErr: false},
+		{interval: -time.Second, wantErr: true},
+		{interval: (math.MaxUint32 + 1) * time.Second, wantErr: true},
 	}
-
 	for _, test := range tests {
-		if got := sps30.ShdlcCRC(test.addr+test.cmd, test.dataLen, test.data); got != test.want {
-			t.Errorf("shdlcCRC(0x%x + 0x%x, 0x%x, 0x%x) = 0x%x. Expected 0x%x", test.addr, test.cmd, test.want, test.data, got, test.want)
+		mockUart := newScriptedUart([]byte{0x7e, 0x00, 0x80, 0x00, 0x00, 0x7f, 0x7e})
+		device := sps30.New(mockUart)
+		err := device.WriteAutoCleaningInterval(test.interval)
+
+		if (err != nil) != test.wantErr {
+			t.Errorf("WriteAutoCleaningInterval(%v) error = %v, wantErr %v", test.interval, err, test.wantErr)
+		}
+		if test.wantErr {
+			if len(*mockUart.written) != 0 {
+				t.Errorf("WriteAutoCleaningInterval(%v) sent data to the device", test.interval)
+			}
+			continue
+		}
+		if got := hex.EncodeToString((*mockUart.written)[0]); got != test.want {
+			t.Errorf("WriteAutoCleaningInterval(%v) sent 0x%v. Expected 0x%v", test.interval, got, test.want)
 		}
 	}
 }
 
-func TestStuffData(t *testing.T) {
+func TestReadVersionDataTooLong(t *testing.T) {
+	// eight bytes of data, one more than a version response has
+	mockUart := newScriptedUart([]byte{0x7e, 0x00, 0xd1, 0x00, 0x08, 0x02, 0x03, 0x00, 0x07, 0x00, 0x02, 0x00, 0x09, 0x0f, 0x7e})
+	device := sps30.New(mockUart)
 
+	err := device.ReadVersion(&sps30.VersionInfo{})
+	if !errors.Is(err, sps30.ErrDataTooLong) {
+		t.Errorf("ReadVersion() error = %v. Expected %v", err, sps30.ErrDataTooLong)
+	}
+}
+
+func TestReadMeasurementPartialReads(t *testing.T) {
+	uartBuffer := []byte{0x7e, 0x00, 0x03, 0x00, 0x28, 0x3d, 0x20, 0x01, 0xe3, 0x3d, 0x5a, 0xde, 0xcf, 0x3d, 0x81, 0xcc, 0x53, 0x3d, 0x8c, 0x48, 0xae, 0x3e, 0x73, 0x39, 0x7d, 0x5e, 0x3e, 0x97, 0xb9, 0x03, 0x3e, 0x9e, 0x8b, 0x9f, 0x3e, 0x9f, 0xf1, 0x71, 0x3e, 0xa0, 0x4e, 0x18, 0x3f, 0x36, 0x50, 0x12, 0x5a, 0x7e}
+	mockUart := newDribbleUart(uartBuffer)
+	device := sps30.New(mockUart)
+
+	measurement := sps30.Measurement{}
+	if err := device.ReadMeasurement(&measurement); err != nil {
+		t.Fatalf("ReadMeasurement() failed: %v", err)
+	}
+	if measurement != floatMeasurement {
+		t.Errorf("ReadMeasurement() = %+v. Expected %+v", measurement, floatMeasurement)
+	}
+}
+
+func TestResponseMismatch(t *testing.T) {
 	tests := []struct {
-		dataLen        int
-		inputData      []byte
-		outputData     *[sps30.ShdlcFrameMaxTxFrameSize]byte
-		inputPos       int
-		outputPosWant  int
-		outputDataWant []byte
+		name       string
+		uartBuffer []byte
+		wantErr    error
 	}{
 		{
-			dataLen: 0x01, 
-			inputData: []byte{0x00}, 
-			outputData: &[sps30.ShdlcFrameMaxTxFrameSize]byte{},
-			inputPos: 0x00, 
-			outputPosWant: 0x01, 
-			outputDataWant: []byte{0},
+			name:       "response from another address",
+			uartBuffer: []byte{0x7e, 0x01, 0xd1, 0x00, 0x07, 0x02, 0x03, 0x00, 0x07, 0x00, 0x02, 0x00, 0x18, 0x7e},
+			wantErr:    sps30.ErrAddressMismatch,
 		},
 		{
-			dataLen: 0x01, 
-			inputData: []byte{0x7E}, 
-			outputData: &[sps30.ShdlcFrameMaxTxFrameSize]byte{},
-			inputPos: 0x00, 
-			outputPosWant: 0x02, 
-			outputDataWant: []byte{0x7D, 0x5E},
+			name:       "stale response to another command",
+			uartBuffer: []byte{0x7e, 0x00, 0x03, 0x00, 0x07, 0x02, 0x03, 0x00, 0x07, 0x00, 0x02, 0x00, 0xe7, 0x7e},
+			wantErr:    sps30.ErrCommandMismatch,
 		},
-		{
-			dataLen: 0x04, 
-			inputData: []byte{0x7E, 0x7D, 0x11, 0x13}, 
-			outputData: &[sps30.ShdlcFrameMaxTxFrameSize]byte{}, 
-			inputPos: 0, 
-			outputPosWant: 8, 
-			outputDataWant: []byte{0x7D, 0x5E, 0x7D, 0x5D, 0x7D, 0x31, 0x7D, 0x33},
+	}
+	for _, test := range tests {
+		mockUart := newScriptedUart(test.uartBuffer)
+		device := sps30.New(mockUart)
+		err := device.ReadVersion(&sps30.VersionInfo{})
+
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("%s: ReadVersion() error = %v. Expected %v", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestDeviceError(t *testing.T) {
+	tests := []struct {
+		uartBuffer []byte
+		want       sps30.DeviceError
+	}{
+		{ // Read Measurement, Command not allowed in current state
+			uartBuffer: []byte{0x7e, 0x00, 0x03, 0x43, 0x00, 0xb9, 0x7e},
+			want:       sps30.DeviceError{Cmd: 0x03, State: 67},
 		},
-		{
-			dataLen: 0x04, 
-			inputData: []byte{0x34, 0x03, 0x00, 0xF1}, 
-			outputData: &[sps30.ShdlcFrameMaxTxFrameSize]byte{}, 
-			inputPos: 0, 
-			outputPosWant: 4, 
-			outputDataWant: []byte{0x34, 0x03, 0x00, 0xF1},
+		{ // Read Measurement, Unknown command
+			uartBuffer: []byte{0x7e, 0x00, 0x03, 0x02, 0x00, 0xfa, 0x7e},
+			want:       sps30.DeviceError{Cmd: 0x03, State: 2},
 		},
 	}
 	for _, test := range tests {
-		outputPos := sps30.StuffData(test.dataLen, test.inputData, test.outputData, test.inputPos)
-		if outputPos != test.outputPosWant {
-			t.Errorf("stuffData(0x%x, 0x%x, *[sps30.ShdlcFrameMaxTxFrameSize]byte, 0x%x) = 0x%x. Expected return value 0x%x", test.dataLen, test.inputData, test.inputPos, outputPos, test.outputPosWant)
+		mockUart := newScriptedUart(test.uartBuffer)
+		device := sps30.New(mockUart)
+		err := device.ReadMeasurement(&sps30.Measurement{})
+
+		var deviceErr *sps30.DeviceError
+		if !errors.As(err, &deviceErr) {
+			t.Errorf("ReadMeasurement() error = %v. Expected a DeviceError", err)
+			continue
+		}
+		if *deviceErr != test.want {
+			t.Errorf("ReadMeasurement() error = %+v. Expected %+v", *deviceErr, test.want)
 		}
-		if !bytes.Equal((*test.outputData)[:test.outputPosWant], test.outputDataWant) {
-			t.Errorf("stuffData(0x%x, 0x%x, *[sps30.ShdlcFrameMaxTxFrameSize]byte, 0x%x): output data '0x%x' does not match expected '0x%x'", test.dataLen, test.inputData, test.inputPos, (*test.outputData)[:test.outputPosWant], test.outputDataWant)
+	}
+}
+
+func TestReadMeasurementContextDeadline(t *testing.T) {
+	mockUart := newDribbleUart(nil)
+	device := sps30.New(mockUart)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := device.ReadMeasurementContext(ctx, &sps30.Measurement{})
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ReadMeasurementContext() error = %v. Expected context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("ReadMeasurementContext() returned after %v. Expected to return at the context deadline", elapsed)
+	}
+	if *mockUart.resets != 1 {
+		t.Errorf("ReadMeasurementContext() reset the input buffer %d times. Expected 1", *mockUart.resets)
+	}
+}
+
+func TestReadVersionContextCancel(t *testing.T) {
+	mockUart := newDribbleUart(nil)
+	device := sps30.New(mockUart)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	err := device.ReadVersionContext(ctx, &sps30.VersionInfo{})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadVersionContext() error = %v. Expected context.Canceled", err)
+	}
+	if *mockUart.resets != 1 {
+		t.Errorf("ReadVersionContext() reset the input buffer %d times. Expected 1", *mockUart.resets)
+	}
+}
+
+func TestStopMeasurementContextCancelled(t *testing.T) {
+	mockUart := newScriptedUart([]byte{0x7e, 0x00, 0x01, 0x00, 0x00, 0xfe, 0x7e})
+	device := sps30.New(mockUart)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := device.StopMeasurementContext(ctx)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("StopMeasurementContext() error = %v. Expected context.Canceled", err)
+	}
+	if len(*mockUart.written) != 0 {
+		t.Errorf("StopMeasurementContext() sent data to the device after ctx was cancelled")
+	}
+}
+
+func TestConcurrentCommands(t *testing.T) {
+	mockUart := newRespondingUart(map[byte][][]byte{
+		0x03: {measurementResponse},
+		0xd1: {{0x7e, 0x00, 0xd1, 0x00, 0x07, 0x02, 0x03, 0x00, 0x07, 0x00, 0x02, 0x00, 0x19, 0x7e}},
+		0xd2: {{0x7e, 0x00, 0xd2, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x28, 0x7e}},
+	})
+	device := sps30.New(mockUart)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 300)
+
+	for i := 0; i < 3; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 30; j++ {
+				errs <- device.ReadMeasurement(&sps30.Measurement{})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 30; j++ {
+				errs <- device.ReadVersion(&sps30.VersionInfo{})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 30; j++ {
+				_, err := device.ReadStatusRegister(false)
+				errs <- err
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent command failed: %v", err)
 		}
 	}
+	if *mockUart.interleaved != 0 {
+		t.Errorf("%d requests were sent before the previous response was read", *mockUart.interleaved)
+	}
 }
 
-func TestUnstuffByte(t *testing.T) {
+func TestConcurrentStreamAndCommands(t *testing.T) {
+	mockUart := newRespondingUart(map[byte][][]byte{
+		0x00: {startMeasurementResponse},
+		0x01: {stopMeasurementResponse},
+		0x03: {measurementResponse},
+		0xd1: {{0x7e, 0x00, 0xd1, 0x00, 0x07, 0x02, 0x03, 0x00, 0x07, 0x00, 0x02, 0x00, 0x19, 0x7e}},
+	})
+	device := sps30.New(mockUart)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	samples, errs := device.Stream(ctx, time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			if err := device.ReadVersion(&sps30.VersionInfo{}); err != nil {
+				t.Errorf("ReadVersion() during Stream() failed: %v", err)
+			}
+		}
+	}()
 
-	createUint8Pointer := func(val uint8) *uint8 {
-		return &val
+	for i := 0; i < 10; i++ {
+		select {
+		case <-samples:
+		case err := <-errs:
+			t.Errorf("Stream() reported an error: %v", err)
+		}
 	}
+	<-done
 
+	cancel()
+	for range samples {
+	}
+	for err := range errs {
+		t.Errorf("Stream() reported an error: %v", err)
+	}
+
+	if *mockUart.interleaved != 0 {
+		t.Errorf("%d requests were sent before the previous response was read", *mockUart.interleaved)
+	}
+}
+
+func TestExecute(t *testing.T) {
 	tests := []struct {
-		data           []byte
-		index          int
-		outputData     *uint8
-		outputPosWant  int
-		outputDataWant uint8
+		cmd        byte
+		payload    []byte
+		uartBuffer []byte
+		wantTx     string
+		want       sps30.Response
+		wantState  uint8
 	}{
-	{
-		data: []byte{0x7d, 0x31, 0x03, 0x05}, 
-		index: 0,
-		outputData: createUint8Pointer(0), 
-		outputPosWant: 2, 
-		outputDataWant: 0x11,
-	},
-	{
-		data: []byte{0x7d, 0x31, 0x03, 0x05}, 
-		index: 2,
-		outputData: createUint8Pointer(0), 
-		outputPosWant: 3, 
-		outputDataWant: 0x03,
-	},
-	{
-		data: []byte{0x7d, 0x31, 0x7D, 0x33}, 
-		index: 2,
-		outputData: createUint8Pointer(0), 
-		outputPosWant: 4, 
-		outputDataWant: 0x13,
-	},
-	{
-		data: []byte{0xFF, 0x31, 0x03, 0x05}, 
-		index: 0,
-		outputData: createUint8Pointer(0), 
-		outputPosWant: 1, 
-		outputDataWant: 0xFF,
-	},
+		{ // read version
+			cmd:        0xd1,
+			uartBuffer: []byte{0x7e, 0x00, 0xd1, 0x00, 0x07, 0x02, 0x03, 0x00, 0x07, 0x00, 0x02, 0x00, 0x19, 0x7e},
+			wantTx:     "7e00d1002e7e",
+			want:       sps30.Response{Addr: 0x00, Cmd: 0xd1, Data: []byte{0x02, 0x03, 0x00, 0x07, 0x00, 0x02, 0x00}},
+		},
+		{ // read serial number, with a subcommand
+			cmd:        0xd0,
+			payload:    []byte{0x03},
+			uartBuffer: []byte{0x7e, 0x00, 0xd0, 0x00, 0x03, 0x41, 0x42, 0x00, 0xa9, 0x7e},
+			wantTx:     "7e00d001032b7e",
+			want:       sps30.Response{Addr: 0x00, Cmd: 0xd0, Data: []byte{0x41, 0x42, 0x00}},
+		},
+		{ // read measured values in Idle-mode
+			cmd:        0x03,
+			uartBuffer: []byte{0x7e, 0x00, 0x03, 0x43, 0x00, 0xb9, 0x7e},
+			wantTx:     "7e000300fc7e",
+			want:       sps30.Response{Addr: 0x00, Cmd: 0x03, State: 0x43, Data: []byte{}},
+			wantState:  0x43,
+		},
 	}
+
 	for _, test := range tests {
-		outputPos := sps30.UnstuffByte(test.data, test.index, test.outputData)
-		if outputPos != test.outputPosWant {
-			t.Errorf("unstuffByte(0x%x, 0x%x, []) = 0x%x. Expected return value 0x%x", test.data, test.index, outputPos, test.outputPosWant)
+		mockUart := newScriptedUart(test.uartBuffer)
+		device := sps30.New(mockUart)
+		got, err := device.Execute(test.cmd, test.payload)
+
+		var device_err *sps30.DeviceError
+		if test.wantState != 0 {
+			if !errors.As(err, &device_err) || device_err.State != test.wantState {
+				t.Errorf("Execute(0x%02x) error = %v. Expected state 0x%02x", test.cmd, err, test.wantState)
+			}
+		} else if err != nil {
+			t.Fatalf("Execute(0x%02x) failed: %v", test.cmd, err)
 		}
-		if *test.outputData != test.outputDataWant {
-			t.Errorf("unstuffByte(0x%x, 0x%x, []): output data '0x%x' does not match expected '0x%x'", test.data, test.index, *test.outputData, test.outputDataWant)
+
+		if got.Addr != test.want.Addr || got.Cmd != test.want.Cmd || got.State != test.want.State || !bytes.Equal(got.Data, test.want.Data) {
+			t.Errorf("Execute(0x%02x) = %+v. Expected %+v", test.cmd, got, test.want)
+		}
+		if tx := hex.EncodeToString((*mockUart.written)[0]); tx != test.wantTx {
+			t.Errorf("Execute(0x%02x) sent 0x%v. Expected 0x%v", test.cmd, tx, test.wantTx)
 		}
 	}
 }
 
-func Example() {
+func TestExecutePayloadTooLong(t *testing.T) {
+	mockUart := newScriptedUart()
+	device := sps30.New(mockUart)
 
-	mode := &serial.Mode{
-		BaudRate: 115200,
-		DataBits: 8,
-		Parity:   serial.NoParity,
-		StopBits: serial.OneStopBit,
+	_, err := device.Execute(0x80, make([]byte, 256))
+	if err == nil {
+		t.Errorf("Execute() with a 256 byte payload succeeded")
 	}
+	if len(*mockUart.written) != 0 {
+		t.Errorf("Execute() sent a frame with a 256 byte payload")
+	}
+}
+
+func Example() {
 
 	log.Println("Connecting to UART")
 
-	uart, err := serial.Open("/dev/ttyUSB0", mode) //should be read from a config file or something
+	device, err := sps30.Open("/dev/ttyUSB0", sps30.WithWakeup()) //should be read from a config file or something
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer device.Close()
 	log.Println("Successfully Connected")
 
-	device := sps30.New(uart)
-
 	//Create a struct that is passed to a function then is populated. th
 	version_info := sps30.VersionInfo{}
 	err = device.ReadVersion(&version_info)
@@ -448,20 +935,20 @@ func Example() {
 		version_info.SHDLCMajor,
 		version_info.SHDLCMinor)
 
-	err = device.StartMeasurement()
-	if err != nil {
-		log.Fatal("error starting measurement")
-	}
-
-	measurement := sps30.Measurement{}
-	for {
-
-		err = device.ReadMeasurement(&measurement)
-		if err != nil {
-			fmt.Printf("[-] error reading measurement: %v", err)
-		} else {
+	// the measurement is started, and stopped again on Ctrl+C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	samples, errs := device.Stream(ctx, time.Second)
+	for samples != nil || errs != nil {
+		select {
+		case sample, ok := <-samples:
+			if !ok {
+				samples = nil
+				continue
+			}
 			fmt.Printf(`
-measured values:
+measured values at %v:
 				%0.2f pm1.0
 				%0.2f pm2.5
 				%0.2f pm4.0
@@ -473,11 +960,17 @@ measured values:
 				%0.2f nc10.0
 				%0.2f typical particle size
 `,
-				measurement.Mc1p0, measurement.Mc2p5, measurement.Mc4p0, measurement.Mc10p0, measurement.Nc0p5,
-				measurement.Nc1p0, measurement.Nc2p5, measurement.Nc4p0, measurement.Nc10p0,
-				measurement.TypicalParticleSize)
+				sample.Time.Format(time.TimeOnly),
+				sample.Mc1p0, sample.Mc2p5, sample.Mc4p0, sample.Mc10p0, sample.Nc0p5,
+				sample.Nc1p0, sample.Nc2p5, sample.Nc4p0, sample.Nc10p0,
+				sample.TypicalParticleSize)
+
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			fmt.Printf("[-] error reading measurement: %v\n", err)
 		}
-
-		time.Sleep(time.Second)
 	}
 }
@@ -0,0 +1,127 @@
+package sps30
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// shortest and longest time Stream waits between reads while the device keeps failing
+const (
+	streamMinBackoff = 100 * time.Millisecond
+	streamMaxBackoff = 30 * time.Second
+)
+
+// Sample is a Measurement together with the time it was read from the device
+type Sample struct {
+	Time time.Time
+	Measurement
+}
+
+// Stream puts the SPS30 in Measure-mode and reads a Sample every interval until ctx is done,
+// after which the measurement is stopped and both channels are closed.
+//
+// Reads without new values are skipped. Failed reads are sent on the error channel and retried
+// with an exponential backoff of up to streamMaxBackoff, and the measurement is restarted if the
+// device was found in Idle-mode, e.g. after a power cycle. Both channels must be received from,
+// or the stream blocks until ctx is done. A non-positive interval is reported on the error
+// channel without starting the measurement.
+func (d *Device) Stream(ctx context.Context, interval time.Duration) (<-chan Sample, <-chan error) {
+	samples := make(chan Sample)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(samples)
+
+		send_error := func(err error) bool {
+			select {
+			case errs <- err:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		if interval <= 0 {
+			send_error(fmt.Errorf("stream interval must be positive, got %v", interval))
+			return
+		}
+
+		err := d.StartMeasurementFormatContext(ctx, d.outputFormat())
+		if err != nil {
+			send_error(err)
+			return
+		}
+
+		wait := time.Duration(0)
+		backoff := max(interval, streamMinBackoff)
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				d.stopStreamMeasurement(errs)
+				return
+			}
+
+			measurement := Measurement{}
+			err := d.ReadMeasurementContext(ctx, &measurement)
+
+			switch {
+			case err == nil:
+				backoff = max(interval, streamMinBackoff)
+				wait = interval
+
+				select {
+				case samples <- Sample{Time: time.Now(), Measurement: measurement}:
+				case <-ctx.Done():
+					d.stopStreamMeasurement(errs)
+					return
+				}
+
+			case errors.Is(err, ErrNoNewData):
+				wait = interval
+
+			case ctx.Err() != nil:
+				continue
+
+			default:
+				var device_err *DeviceError
+				if errors.As(err, &device_err) && device_err.State == stateCommandNotAllowed {
+					err = d.StartMeasurementFormatContext(ctx, d.outputFormat())
+				}
+
+				if err != nil && !send_error(err) {
+					continue
+				}
+
+				wait = backoff
+				backoff = min(backoff*2, streamMaxBackoff)
+			}
+
+			timer.Reset(wait)
+		}
+	}()
+
+	return samples, errs
+}
+
+// stopStreamMeasurement stops the measurement after the stream's context is done,
+// reporting a failure on errs if nobody is waiting for errors anymore
+func (d *Device) stopStreamMeasurement(errs chan<- error) {
+	// the device gets a second try at responding to the stop command
+	ctx, cancel := context.WithTimeout(context.Background(), 2*d.timeout)
+	defer cancel()
+
+	err := d.StopMeasurementContext(ctx)
+	if err != nil {
+		select {
+		case errs <- fmt.Errorf("stream could not stop measurement: %w", err):
+		default:
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package sps30_test
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MasandeM/sps30"
+)
+
+// respondingUart answers every frame written to it with the next canned response for its command.
+// The last response for a command is repeated once the others are used up. It is safe for concurrent
+// use, returns a single byte per Read and counts requests written while the response to a previous
+// request was still unread.
+type respondingUart struct {
+	fakeUart
+	mu          *sync.Mutex
+	responses   map[byte][][]byte
+	commands    *[]byte
+	interleaved *int
+}
+
+func newRespondingUart(responses map[byte][][]byte) respondingUart {
+	return respondingUart{
+		fakeUart:    fakeUart{Data: new(bytes.Buffer)},
+		mu:          new(sync.Mutex),
+		responses:   responses,
+		commands:    &[]byte{},
+		interleaved: new(int),
+	}
+}
+
+func (r respondingUart) Write(p []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(p) < 3 {
+		return len(p), nil
+	}
+
+	if (*r.Data).Len() > 0 {
+		*r.interleaved += 1
+	}
+
+	cmd := p[2]
+	*r.commands = append(*r.commands, cmd)
+
+	queue := r.responses[cmd]
+	if len(queue) > 0 {
+		(*r.Data).Write(queue[0])
+		if len(queue) > 1 {
+			r.responses[cmd] = queue[1:]
+		}
+	}
+	return len(p), nil
+}
+
+func (r respondingUart) Read(p []byte) (n int, err error) {
+	// a single byte per read leaves room for another goroutine to write in between
+	runtime.Gosched()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(p) == 0 || (*r.Data).Len() == 0 {
+		return 0, nil
+	}
+	return (*r.Data).Read(p[:1])
+}
+
+var (
+	startMeasurementResponse = []byte{0x7e, 0x00, 0x00, 0x00, 0x00, 0xff, 0x7e}
+	stopMeasurementResponse  = []byte{0x7e, 0x00, 0x01, 0x00, 0x00, 0xfe, 0x7e}
+	noNewDataResponse        = []byte{0x7e, 0x00, 0x03, 0x00, 0x00, 0xfc, 0x7e}
+	notAllowedResponse       = []byte{0x7e, 0x00, 0x03, 0x43, 0x00, 0xb9, 0x7e}
+	measurementResponse      = []byte{0x7e, 0x00, 0x03, 0x00, 0x28, 0x3f, 0xc0, 0x00, 0x00, 0x40, 0x20, 0x00, 0x00, 0x40, 0x60, 0x00, 0x00, 0x40, 0x90, 0x00, 0x00, 0x41, 0x20, 0x00, 0x00, 0x41, 0x30, 0x00, 0x00, 0x41, 0x40, 0x00, 0x00, 0x41, 0x50, 0x00, 0x00, 0x41, 0x60, 0x00, 0x00, 0x3f, 0x19, 0x99, 0x9a, 0xf5, 0x7e}
+)
+
+func TestStream(t *testing.T) {
+	mockUart := newRespondingUart(map[byte][][]byte{
+		0x00: {startMeasurementResponse},
+		0x01: {stopMeasurementResponse},
+		0x03: {measurementResponse, noNewDataResponse, measurementResponse, noNewDataResponse},
+	})
+	device := sps30.New(mockUart)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Now()
+	samples, errs := device.Stream(ctx, 5*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case sample := <-samples:
+			if sample.Mc1p0 != 1.5 || sample.TypicalParticleSize != 0.6 {
+				t.Errorf("sample %d = %+v. Expected the canned measurement", i, sample.Measurement)
+			}
+			if sample.Time.Before(start) {
+				t.Errorf("sample %d has time %v before the stream started", i, sample.Time)
+			}
+		case err := <-errs:
+			t.Fatalf("Stream() reported an error: %v", err)
+		case <-time.After(time.Second):
+			t.Fatalf("Stream() did not deliver sample %d", i)
+		}
+	}
+
+	cancel()
+	for range samples {
+	}
+	for err := range errs {
+		t.Errorf("Stream() reported an error: %v", err)
+	}
+
+	commands := *mockUart.commands
+	if commands[0] != 0x00 {
+		t.Errorf("Stream() sent command 0x%02x first. Expected Start Measurement", commands[0])
+	}
+	if commands[len(commands)-1] != 0x01 {
+		t.Errorf("Stream() sent command 0x%02x last. Expected Stop Measurement", commands[len(commands)-1])
+	}
+}
+
+func TestStreamRestartsMeasurement(t *testing.T) {
+	mockUart := newRespondingUart(map[byte][][]byte{
+		0x00: {startMeasurementResponse},
+		0x01: {stopMeasurementResponse},
+		0x03: {notAllowedResponse, measurementResponse},
+	})
+	device := sps30.New(mockUart)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	samples, errs := device.Stream(ctx, 5*time.Millisecond)
+
+	select {
+	case <-samples:
+	case err := <-errs:
+		t.Fatalf("Stream() reported an error: %v", err)
+	case <-time.After(time.Second):
+		t.Fatalf("Stream() did not deliver a sample")
+	}
+
+	cancel()
+	for range samples {
+	}
+	for range errs {
+	}
+
+	starts := bytes.Count(*mockUart.commands, []byte{0x00})
+	if starts != 2 {
+		t.Errorf("Stream() started the measurement %d times. Expected 2", starts)
+	}
+}
+
+func TestStreamReportsErrors(t *testing.T) {
+	mockUart := newRespondingUart(map[byte][][]byte{
+		0x00: {startMeasurementResponse},
+		0x01: {stopMeasurementResponse},
+		0x03: {{0x7e, 0x00, 0x03, 0x00, 0x00, 0x00, 0x7e}, measurementResponse},
+	})
+	device := sps30.New(mockUart)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	samples, errs := device.Stream(ctx, 5*time.Millisecond)
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Errorf("Stream() reported a nil error")
+		}
+	case <-samples:
+		t.Fatalf("Stream() delivered a sample before reporting the corrupt frame")
+	case <-time.After(time.Second):
+		t.Fatalf("Stream() did not report the corrupt frame")
+	}
+
+	select {
+	case <-samples:
+	case err := <-errs:
+		t.Fatalf("Stream() reported an error: %v", err)
+	case <-time.After(time.Second):
+		t.Fatalf("Stream() did not recover after the corrupt frame")
+	}
+
+	cancel()
+	for range samples {
+	}
+	for range errs {
+	}
+}
+
+func TestStreamRejectsInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		mockUart := newRespondingUart(map[byte][][]byte{})
+		device := sps30.New(mockUart)
+
+		samples, errs := device.Stream(context.Background(), interval)
+
+		if err := <-errs; err == nil {
+			t.Errorf("Stream(%v) did not report an error", interval)
+		}
+		if _, ok := <-samples; ok {
+			t.Errorf("Stream(%v) delivered a sample", interval)
+		}
+		if len(*mockUart.commands) != 0 {
+			t.Errorf("Stream(%v) sent commands to the device", interval)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package sps30
+
+import (
+	"io"
+	"time"
+
+	"github.com/MasandeM/sps30/shdlc"
+	"go.bug.st/serial"
+)
+
+// Transport is the byte stream a Device talks SHDLC over, such as a serial port,
+// a TCP connection to a serial server (e.g. ser2net) or an in-memory pipe.
+//
+// A Transport may also implement ReadTimeoutSetter or ReadDeadlineSetter so reads
+// return while waiting for a response, and InputBufferResetter so stale input can be discarded.
+// Without either timeout capability a Read blocks until data arrives, and deadlines and
+// cancellation are only noticed between reads.
+type Transport interface {
+	io.ReadWriter
+}
+
+// ReadTimeoutSetter is implemented by transports that bound each Read with a timeout, like serial.Port.
+// A Read that times out returns 0 bytes and no error.
+type ReadTimeoutSetter = shdlc.ReadTimeoutSetter
+
+// ReadDeadlineSetter is implemented by transports that bound reads with a deadline, like net.Conn.
+// A Read that passes the deadline returns an error with a Timeout() method reporting true.
+type ReadDeadlineSetter = shdlc.ReadDeadlineSetter
+
+// InputBufferResetter is implemented by transports that can discard received data that has not been read yet.
+type InputBufferResetter = shdlc.InputBufferResetter
+
+// serialTransport restricts a serial.Port to the capabilities a Device uses
+type serialTransport struct {
+	port serial.Port
+}
+
+// NewSerialTransport adapts a serial.Port opened with the SPS30 settings (115200 baud, 8N1) to a Transport.
+// A serial.Port can also be passed to New directly, this adapter only hides the port's other methods.
+func NewSerialTransport(port serial.Port) Transport {
+	return serialTransport{port: port}
+}
+
+func (s serialTransport) Read(p []byte) (int, error) {
+	return s.port.Read(p)
+}
+
+func (s serialTransport) Write(p []byte) (int, error) {
+	return s.port.Write(p)
+}
+
+func (s serialTransport) SetReadTimeout(t time.Duration) error {
+	return s.port.SetReadTimeout(t)
+}
+
+func (s serialTransport) ResetInputBuffer() error {
+	return s.port.ResetInputBuffer()
+}
+
+func (s serialTransport) Close() error {
+	return s.port.Close()
+}
+
+// resetInputBuffer discards unread input on the transport, if the transport supports it
+func (d *Device) resetInputBuffer() error {
+	return d.client.ResetInput()
+}
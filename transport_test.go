@@ -0,0 +1,97 @@
+package sps30_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/MasandeM/sps30"
+)
+
+// plainTransport implements nothing but io.ReadWriter
+type plainTransport struct {
+	rx io.Reader
+	tx *bytes.Buffer
+}
+
+func (p plainTransport) Read(b []byte) (int, error) {
+	return p.rx.Read(b)
+}
+
+func (p plainTransport) Write(b []byte) (int, error) {
+	return p.tx.Write(b)
+}
+
+func TestPlainTransport(t *testing.T) {
+	transport := plainTransport{
+		rx: bytes.NewReader([]byte{0x7e, 0x00, 0xd1, 0x00, 0x07, 0x02, 0x03, 0x00, 0x07, 0x00, 0x02, 0x00, 0x19, 0x7e}),
+		tx: new(bytes.Buffer),
+	}
+	device := sps30.New(transport)
+
+	version := sps30.VersionInfo{}
+	if err := device.ReadVersion(&version); err != nil {
+		t.Fatalf("ReadVersion() failed: %v", err)
+	}
+
+	want := sps30.VersionInfo{FirmwarMajor: 2, FirmwarMinor: 3, HardwarRevision: 7, SHDLCMajor: 2, SHDLCMinor: 0}
+	if version != want {
+		t.Errorf("ReadVersion() = %+v. Expected %+v", version, want)
+	}
+	if !bytes.Equal(transport.tx.Bytes(), []byte{0x7e, 0x00, 0xd1, 0x00, 0x2e, 0x7e}) {
+		t.Errorf("ReadVersion() sent 0x%x. Expected 0x7e00d1002e7e", transport.tx.Bytes())
+	}
+}
+
+func TestPipeTransport(t *testing.T) {
+	host, sensor := net.Pipe()
+	defer host.Close()
+	defer sensor.Close()
+
+	go func() {
+		request := make([]byte, 64)
+		sensor.Read(request)
+		sensor.Write([]byte{0x7e, 0x00, 0x01})
+		sensor.Write([]byte{0x00, 0x00, 0xfe, 0x7e})
+	}()
+
+	device := sps30.New(host)
+	if err := device.StopMeasurement(); err != nil {
+		t.Errorf("StopMeasurement() failed: %v", err)
+	}
+}
+
+func TestPipeTransportDeadline(t *testing.T) {
+	host, sensor := net.Pipe()
+	defer host.Close()
+	defer sensor.Close()
+
+	// the sensor reads the request but never answers
+	go io.Copy(io.Discard, sensor)
+
+	device := sps30.New(host)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := device.StopMeasurementContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("StopMeasurementContext() error = %v. Expected context.DeadlineExceeded", err)
+	}
+}
+
+func TestSerialTransport(t *testing.T) {
+	mockUart := newScriptedUart([]byte{0x7e, 0x00, 0xd3, 0x00, 0x00, 0x2c, 0x7e})
+	device := sps30.New(sps30.NewSerialTransport(mockUart))
+
+	if err := device.Reset(); err != nil {
+		t.Fatalf("Reset() failed: %v", err)
+	}
+	if *mockUart.resets != 1 {
+		t.Errorf("Reset() reset the input buffer %d times. Expected 1", *mockUart.resets)
+	}
+}